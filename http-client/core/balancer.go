@@ -0,0 +1,417 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoEndpoint = errors.New("no endpoint available")
+
+const (
+	// 请求数据中记录已经尝试过的节点
+	balancerDataKey = "core.Balancer"
+
+	// 默认连续失败5次摘除节点
+	defaultMaxFailures = 5
+	// 默认摘除30秒
+	defaultEjectionTime = 30 * time.Second
+	// 默认最多摘除一半节点
+	defaultMaxEjectionPercent = 0.5
+	// 摘除时间最多为EjectionTime的倍数
+	maxEjectionMultiplier = 10
+	// 一致性哈希每个节点的虚拟节点数
+	hashReplicas = 100
+)
+
+// 负载均衡策略
+type BalanceStrategy int
+
+const (
+	// 轮询
+	RoundRobin BalanceStrategy = iota
+	// 最少处理中请求
+	LeastInFlight
+	// 一致性哈希，相同的键总是选择相同的节点（节点不可用时顺延）
+	ConsistentHash
+)
+
+// 负载均衡配置
+//
+// Resolver 服务发现。请求URL的主机名（如 http://user-service/v1/users 中的user-service）作为逻辑服务名
+//
+// Strategy 负载均衡策略。默认为轮询
+//
+// HashKey 一致性哈希的键。如果为nil，默认为请求路径
+//
+// MaxFailures 连续失败（请求失败或者5xx）多少次摘除节点。如果为零，默认为5次；如果为负数，不摘除
+//
+// EjectionTime 摘除时间，连续第n次摘除时为n倍，最多为10倍。如果为零，默认为30秒
+//
+// MaxEjectionPercent 每个服务最多摘除节点的比例，至少保留一个节点。如果为零，默认为0.5
+type BalancerSettings struct {
+	Resolver           Resolver
+	Strategy           BalanceStrategy
+	HashKey            func(httpReq *http.Request) string
+	MaxFailures        int
+	EjectionTime       time.Duration
+	MaxEjectionPercent float64
+}
+
+// 节点状态
+type EndpointStats struct {
+	Address  string
+	InFlight int
+	// 连续失败次数
+	Failures int
+	// 摘除次数，成功之后清零
+	Ejections int
+	// 摘除到期时间，没有摘除时为零
+	EjectedUntil time.Time
+}
+
+type endpoint struct {
+	address   string
+	inFlight  int
+	failures  int
+	ejections int
+	until     time.Time
+}
+
+func (e *endpoint) ejected(now time.Time) bool {
+	return now.Before(e.until)
+}
+
+// 服务状态
+type balancedService struct {
+	endpoints map[string]*endpoint
+	next      uint64
+	// 一致性哈希环，节点列表变化时重建
+	ringKey string
+	ring    []hashNode
+}
+
+type hashNode struct {
+	hash    uint32
+	address string
+}
+
+// 负载均衡
+//   客户端通过SetBalancer()设置之后，请求URL的主机名为逻辑服务名时，
+//   每一次尝试（包括重试以及对冲）选择一个节点，改写请求URL的主机（以及协议），
+//   重试时优先选择还没有尝试过的节点。
+//   连续失败的节点被摘除一段时间（被动健康检查）。
+//   节点选择在TransportHook的BeforeSend之前，签名等钩子看到的是改写之后的请求。
+type Balancer struct {
+	settings BalancerSettings
+
+	mutex    sync.Mutex
+	services map[string]*balancedService
+}
+
+// 新建负载均衡
+func NewBalancer(settings BalancerSettings) *Balancer {
+	if settings.MaxFailures == 0 {
+		settings.MaxFailures = defaultMaxFailures
+	}
+	if settings.EjectionTime <= 0 {
+		settings.EjectionTime = defaultEjectionTime
+	}
+	if settings.MaxEjectionPercent <= 0 {
+		settings.MaxEjectionPercent = defaultMaxEjectionPercent
+	}
+	if nil == settings.HashKey {
+		settings.HashKey = func(httpReq *http.Request) string {
+			return httpReq.URL.Path
+		}
+	}
+	return &Balancer{settings: settings, services: make(map[string]*balancedService)}
+}
+
+// 服务各个节点的状态
+func (b *Balancer) Stats(service string) []EndpointStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s, ok := b.services[service]
+	if !ok {
+		return nil
+	}
+	stats := make([]EndpointStats, 0, len(s.endpoints))
+	for _, e := range s.endpoints {
+		stats = append(stats, EndpointStats{
+			Address:      e.address,
+			InFlight:     e.inFlight,
+			Failures:     e.failures,
+			Ejections:    e.ejections,
+			EjectedUntil: e.until,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Address < stats[j].Address })
+	return stats
+}
+
+// 为一次尝试选择节点
+// @params tried 已经尝试过的节点
+// @return ok    是否为需要负载均衡的服务
+func (b *Balancer) pick(service string, httpReq *http.Request, tried map[string]bool) (address string, ok bool, err error) {
+	addresses, ok := b.settings.Resolver.Resolve(service)
+	if !ok {
+		return "", false, nil
+	}
+	if 0 == len(addresses) {
+		return "", true, ErrNoEndpoint
+	}
+	var key string
+	if b.settings.Strategy == ConsistentHash {
+		key = b.settings.HashKey(httpReq)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := b.service(service, addresses)
+	now := time.Now()
+	// 优先选择没有摘除并且没有尝试过的节点，其次没有摘除的节点，最后所有节点
+	candidates := make([]*endpoint, 0, len(addresses))
+	for _, filter := range []func(e *endpoint) bool{
+		func(e *endpoint) bool { return !e.ejected(now) && !tried[e.address] },
+		func(e *endpoint) bool { return !e.ejected(now) },
+		func(e *endpoint) bool { return true },
+	} {
+		for _, address := range addresses {
+			if e := s.endpoints[address]; filter(e) {
+				candidates = append(candidates, e)
+			}
+		}
+		if len(candidates) > 0 {
+			break
+		}
+	}
+
+	var e *endpoint
+	switch b.settings.Strategy {
+	case LeastInFlight:
+		// 处理中请求相同时轮询
+		start := int(s.next % uint64(len(candidates)))
+		s.next++
+		for i := range candidates {
+			c := candidates[(start+i)%len(candidates)]
+			if nil == e || c.inFlight < e.inFlight {
+				e = c
+			}
+		}
+	case ConsistentHash:
+		e = s.lookup(addresses, key, candidates)
+	default:
+		e = candidates[s.next%uint64(len(candidates))]
+		s.next++
+	}
+	e.inFlight++
+	return e.address, true, nil
+}
+
+// 返回服务状态，同步节点列表，调用时需持有锁
+func (b *Balancer) service(name string, addresses []string) *balancedService {
+	s, ok := b.services[name]
+	if !ok {
+		s = &balancedService{endpoints: make(map[string]*endpoint)}
+		b.services[name] = s
+	}
+	current := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		current[address] = true
+		if _, ok := s.endpoints[address]; !ok {
+			s.endpoints[address] = &endpoint{address: address}
+		}
+	}
+	// 删除已经下线的节点，处理中的请求结束时忽略
+	for address, e := range s.endpoints {
+		if !current[address] && 0 == e.inFlight {
+			delete(s.endpoints, address)
+		}
+	}
+	return s
+}
+
+// 一致性哈希查找，顺时针找到第一个候选节点
+func (s *balancedService) lookup(addresses []string, key string, candidates []*endpoint) *endpoint {
+	ringKey := strings.Join(addresses, ",")
+	if ringKey != s.ringKey {
+		s.ringKey = ringKey
+		s.ring = s.ring[:0]
+		for _, address := range addresses {
+			for i := 0; i < hashReplicas; i++ {
+				s.ring = append(s.ring, hashNode{hash: crc32.ChecksumIEEE([]byte(address + "#" + strconv.Itoa(i))), address: address})
+			}
+		}
+		sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	}
+	allowed := make(map[string]*endpoint, len(candidates))
+	for _, c := range candidates {
+		allowed[c.address] = c
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= hash })
+	for i := 0; i < len(s.ring); i++ {
+		if e, ok := allowed[s.ring[(start+i)%len(s.ring)].address]; ok {
+			return e
+		}
+	}
+	return candidates[0]
+}
+
+// 记录一次尝试的结果
+func (b *Balancer) report(service, address string, failed bool) {
+	if b.settings.MaxFailures < 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s, ok := b.services[service]
+	if !ok {
+		return
+	}
+	e, ok := s.endpoints[address]
+	if !ok {
+		return
+	}
+	if !failed {
+		e.failures, e.ejections = 0, 0
+		return
+	}
+	e.failures++
+	now := time.Now()
+	if e.failures < b.settings.MaxFailures || e.ejected(now) {
+		return
+	}
+	// 至少保留一个节点，并且不超过最大摘除比例
+	ejected := 0
+	for _, other := range s.endpoints {
+		if other.ejected(now) {
+			ejected++
+		}
+	}
+	if ejected+1 >= len(s.endpoints) || float64(ejected+1) > b.settings.MaxEjectionPercent*float64(len(s.endpoints)) {
+		return
+	}
+	e.ejections++
+	multiplier := e.ejections
+	if multiplier > maxEjectionMultiplier {
+		multiplier = maxEjectionMultiplier
+	}
+	e.until = now.Add(time.Duration(multiplier) * b.settings.EjectionTime)
+	e.failures = 0
+}
+
+// 请求结束（响应body关闭）
+func (b *Balancer) release(service, address string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if s, ok := b.services[service]; ok {
+		if e, ok := s.endpoints[address]; ok && e.inFlight > 0 {
+			e.inFlight--
+		}
+	}
+}
+
+// 设置负载均衡，为nil时不做负载均衡
+func (c *Client) SetBalancer(balancer *Balancer) *Client {
+	c.balancer = balancer
+	return c
+}
+
+// 选择的节点
+type balancedEndpoint struct {
+	service string
+	address string
+}
+
+type balancedEndpointKey struct{}
+
+// 返回请求选择的节点（host:port 或者 scheme://host:port），没有负载均衡时返回false
+func EndpointFromRequest(httpReq *http.Request) (string, bool) {
+	ep, ok := httpReq.Context().Value(balancedEndpointKey{}).(*balancedEndpoint)
+	if !ok {
+		return "", false
+	}
+	return ep.address, true
+}
+
+// 清理上一次请求已经尝试的节点，重试时避开的节点只在一次请求中有效
+func (c *Client) balanceReset(req Request) {
+	if nil != c.balancer {
+		req.SetHookData(balancerDataKey, nil)
+	}
+}
+
+// 为一次尝试选择节点并改写请求
+func (c *Client) balance(httpReq *http.Request, req Request) (*http.Request, error) {
+	if nil == c.balancer {
+		return httpReq, nil
+	}
+	service := httpReq.URL.Host
+	var tried map[string]bool
+	if data, ok := req.HookData(balancerDataKey); ok {
+		tried, _ = data.(map[string]bool)
+	}
+	if nil == tried {
+		tried = make(map[string]bool)
+		req.SetHookData(balancerDataKey, tried)
+	}
+	address, ok, err := c.balancer.pick(service, httpReq, tried)
+	if !ok || nil != err {
+		return httpReq, err
+	}
+	tried[address] = true
+	host := address
+	if u, err := url.Parse(address); nil == err && u.Scheme != "" && u.Host != "" {
+		httpReq.URL.Scheme = u.Scheme
+		host = u.Host
+	}
+	httpReq.URL.Host = host
+	httpReq.Host = ""
+	ctx := context.WithValue(httpReq.Context(), balancedEndpointKey{}, &balancedEndpoint{service: service, address: address})
+	return httpReq.WithContext(ctx), nil
+}
+
+// 记录一次尝试的结果，响应body关闭时结束
+func (c *Client) balanceDone(httpReq *http.Request, httpResp *http.Response, err error) *http.Response {
+	ep, ok := httpReq.Context().Value(balancedEndpointKey{}).(*balancedEndpoint)
+	if !ok || nil == c.balancer {
+		return httpResp
+	}
+	c.balancer.report(ep.service, ep.address, nil != err || nil == httpResp || httpResp.StatusCode >= 500)
+	if nil == httpResp || nil == httpResp.Body {
+		c.balancer.release(ep.service, ep.address)
+		return httpResp
+	}
+	httpResp.Body = &releaseBody{ReadCloser: httpResp.Body, release: func() { c.balancer.release(ep.service, ep.address) }}
+	return httpResp
+}
+
+// 没有发送的请求，结束处理中的请求但不记录结果
+func (c *Client) balanceRelease(httpReq *http.Request) {
+	if ep, ok := httpReq.Context().Value(balancedEndpointKey{}).(*balancedEndpoint); ok && nil != c.balancer {
+		c.balancer.release(ep.service, ep.address)
+	}
+}
+
+// 响应body关闭时结束处理中的请求
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
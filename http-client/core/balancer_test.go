@@ -0,0 +1,262 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 返回节点名称的服务
+func newEndpointServer(name string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(name))
+	}))
+}
+
+func endpointOf(ts *httptest.Server) string {
+	return strings.TrimPrefix(ts.URL, "http://")
+}
+
+func TestBalancer_RoundRobin(t *testing.T) {
+	a, b := newEndpointServer("a", 200), newEndpointServer("b", 200)
+	defer a.Close()
+	defer b.Close()
+
+	balancer := NewBalancer(BalancerSettings{Resolver: StaticResolver{"user-service": {endpointOf(a), b.URL}}})
+	client := NewClient("test", nil).SetBalancer(balancer)
+	got := ""
+	for i := 0; i < 4; i++ {
+		resp, err := client.DoRequest(&TestRequest{RequestURL: "http://user-service/v1/users"})
+		if nil != err {
+			t.Fatal("DoRequest", err)
+		}
+		got += resp.ToString()
+		resp.Body.Close()
+	}
+	if got != "abab" {
+		t.Fatal("round robin", got)
+	}
+	for _, stats := range balancer.Stats("user-service") {
+		if stats.InFlight != 0 {
+			t.Fatal("in-flight should be released", stats)
+		}
+	}
+
+	// 不是逻辑服务名的请求按原来的URL发送
+	resp, err := client.DoRequest(&TestRequest{RequestURL: a.URL})
+	if nil != err || resp.ToString() != "a" {
+		t.Fatal("unresolved host", err)
+	}
+
+	// 没有节点
+	balancer = NewBalancer(BalancerSettings{Resolver: StaticResolver{"empty": nil}})
+	_, err = NewClient("test", nil).SetBalancer(balancer).DoRequest(&TestRequest{RequestURL: "http://empty/"})
+	if !errors.Is(err, ErrNoEndpoint) {
+		t.Fatal("ErrNoEndpoint", err)
+	}
+}
+
+func TestBalancer_RetryOtherEndpoint(t *testing.T) {
+	bad, good := newEndpointServer("bad", 503), newEndpointServer("good", 200)
+	defer bad.Close()
+	defer good.Close()
+
+	balancer := NewBalancer(BalancerSettings{Resolver: StaticResolver{"svc": {endpointOf(bad), endpointOf(good)}}})
+	client := NewClient("test", nil).SetBalancer(balancer).SetRetryPolicy(&BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+	for i := 0; i < 4; i++ {
+		req := &TestRequest{RequestURL: "http://svc/"}
+		resp, err := client.DoRequest(req)
+		if nil != err || resp.ToString() != "good" {
+			t.Fatal("retry should move to another endpoint", err)
+		}
+		if endpoint, ok := EndpointFromRequest(resp.Request); !ok || endpoint != endpointOf(good) {
+			t.Fatal("EndpointFromRequest", endpoint, ok)
+		}
+	}
+}
+
+func TestBalancer_Ejection(t *testing.T) {
+	bad, good := newEndpointServer("bad", 500), newEndpointServer("good", 200)
+	defer bad.Close()
+	defer good.Close()
+
+	balancer := NewBalancer(BalancerSettings{
+		Resolver:     StaticResolver{"svc": {endpointOf(bad), endpointOf(good)}},
+		MaxFailures:  2,
+		EjectionTime: time.Hour,
+	})
+	client := NewClient("test", nil).SetBalancer(balancer).SetMaxBadRetryCount(1)
+	for i := 0; i < 4; i++ {
+		client.DoRequest(&TestRequest{RequestURL: "http://svc/"})
+	}
+	stats := endpointStats(balancer, "svc")
+	if len(stats) != 2 || stats[endpointOf(bad)].Ejections != 1 || stats[endpointOf(bad)].EjectedUntil.IsZero() {
+		t.Fatal("bad endpoint should be ejected", stats)
+	}
+	for i := 0; i < 4; i++ {
+		resp, err := client.DoRequest(&TestRequest{RequestURL: "http://svc/"})
+		if nil != err || resp.ToString() != "good" {
+			t.Fatal("ejected endpoint should be skipped", err)
+		}
+	}
+
+	// 不超过最大摘除比例
+	good.Close()
+	for i := 0; i < 4; i++ {
+		client.DoRequest(&TestRequest{RequestURL: "http://svc/"})
+	}
+	if stats = endpointStats(balancer, "svc"); stats[endpointOf(good)].Failures < 2 || stats[endpointOf(good)].Ejections != 0 {
+		t.Fatal("max ejection percent", stats)
+	}
+}
+
+func TestBalancer_ReuseRequest(t *testing.T) {
+	a, b := newEndpointServer("a", 200), newEndpointServer("b", 200)
+	defer a.Close()
+	defer b.Close()
+
+	balancer := NewBalancer(BalancerSettings{Resolver: StaticResolver{"svc": {endpointOf(a), endpointOf(b)}}, Strategy: ConsistentHash})
+	client := NewClient("test", nil).SetBalancer(balancer)
+	// 重复使用的请求不避开上一次请求的节点
+	req := &TestRequest{RequestURL: "http://svc/v1/users/1"}
+	first := ""
+	for i := 0; i < 3; i++ {
+		resp, err := client.DoRequest(req)
+		if nil != err {
+			t.Fatal("DoRequest", err)
+		}
+		if i == 0 {
+			first = resp.ToString()
+		} else if resp.ToString() != first {
+			t.Fatal("consistent hash should keep affinity", first, resp.ToString())
+		}
+	}
+}
+
+func TestBalancer_ResponseClose(t *testing.T) {
+	a := newEndpointServer("a", 200)
+	defer a.Close()
+
+	balancer := NewBalancer(BalancerSettings{Resolver: StaticResolver{"svc": {endpointOf(a)}}, Strategy: LeastInFlight})
+	client := NewClient("test", nil).SetBalancer(balancer)
+	resp, err := client.DoRequest(&TestRequest{RequestURL: "http://svc/"})
+	if nil != err || resp.StatusCode != http.StatusOK {
+		t.Fatal("DoRequest", err)
+	}
+	if stats := endpointStats(balancer, "svc"); stats[endpointOf(a)].InFlight != 1 {
+		t.Fatal("in-flight until body is closed", stats)
+	}
+	// 只检查状态码时，Close()释放处理中的请求
+	if err = resp.Close(); nil != err {
+		t.Fatal("Close", err)
+	}
+	if stats := endpointStats(balancer, "svc"); stats[endpointOf(a)].InFlight != 0 {
+		t.Fatal("Close should release in-flight", stats)
+	}
+}
+
+func endpointStats(b *Balancer, service string) map[string]EndpointStats {
+	m := make(map[string]EndpointStats)
+	for _, stats := range b.Stats(service) {
+		m[stats.Address] = stats
+	}
+	return m
+}
+
+func TestBalancer_LeastInFlight(t *testing.T) {
+	b := NewBalancer(BalancerSettings{Resolver: StaticResolver{"svc": {"a:80", "b:80", "c:80"}}, Strategy: LeastInFlight})
+	httpReq, _ := http.NewRequest("GET", "http://svc/", nil)
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		address, _, _ := b.pick("svc", httpReq, nil)
+		seen[address] = true
+	}
+	if len(seen) != 3 {
+		t.Fatal("should spread in-flight requests", seen)
+	}
+	b.release("svc", "b:80")
+	if address, _, _ := b.pick("svc", httpReq, nil); address != "b:80" {
+		t.Fatal("should pick least in-flight", address)
+	}
+}
+
+func TestBalancer_ConsistentHash(t *testing.T) {
+	b := NewBalancer(BalancerSettings{Resolver: StaticResolver{"svc": {"a:80", "b:80", "c:80"}}, Strategy: ConsistentHash})
+	pick := func(path string, tried map[string]bool) string {
+		httpReq, _ := http.NewRequest("GET", "http://svc"+path, nil)
+		address, _, _ := b.pick("svc", httpReq, tried)
+		return address
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		path := "/users/" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		first := pick(path, nil)
+		if pick(path, nil) != first {
+			t.Fatal("same key should pick the same endpoint", path)
+		}
+		if pick(path, map[string]bool{first: true}) == first {
+			t.Fatal("retry should move to the next endpoint", path)
+		}
+		seen[first] = true
+	}
+	if len(seen) != 3 {
+		t.Fatal("keys should spread over endpoints", seen)
+	}
+}
+
+// 写入服务发现文件并设置修改时间
+func writeResolverFile(t *testing.T, path, content string, mtime time.Time) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); nil != err {
+		t.Fatal("WriteFile", err)
+	}
+	if err := os.Chtimes(path, time.Now(), mtime); nil != err {
+		t.Fatal("Chtimes", err)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "services.json")
+	writeResolverFile(t, path, `{"svc": ["a:80"]}`, time.Now())
+
+	r, err := NewFileResolver(path, 10*time.Millisecond)
+	if nil != err {
+		t.Fatal("NewFileResolver", err)
+	}
+	defer r.Close()
+	if endpoints, ok := r.Resolve("svc"); !ok || len(endpoints) != 1 {
+		t.Fatal("Resolve", endpoints, ok)
+	}
+
+	writeResolverFile(t, path, `{"svc": ["a:80", "b:80"]}`, time.Now().Add(time.Second))
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if endpoints, _ := r.Resolve("svc"); len(endpoints) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("file change should be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 加载失败时保留之前的节点
+	writeResolverFile(t, path, `{`, time.Now().Add(2*time.Second))
+	if err = r.Reload(); nil == err || nil == r.Err() {
+		t.Fatal("invalid file should fail")
+	}
+	if endpoints, _ := r.Resolve("svc"); len(endpoints) != 2 {
+		t.Fatal("previous endpoints should be kept", endpoints)
+	}
+
+	if _, err = NewFileResolver(filepath.Join(dir, "missing.json"), 0); nil == err {
+		t.Fatal("missing file should fail")
+	}
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// 响应body超过最大长度，可以通过errors.Is(err, ErrBodyTooLarge)判断
+//
+// Limit 最大长度
+//
+// Size 响应头部Content-Length声明的长度；读取时才超过最大长度的为-1
+//
+// Decoded 是否为解码（解压）之后的长度超过最大长度
+type BodyTooLargeError struct {
+	Limit   int64
+	Size    int64
+	Decoded bool
+}
+
+func (e *BodyTooLargeError) Error() string {
+	switch {
+	case e.Size >= 0:
+		return fmt.Sprintf("%v: content length %d exceeds limit %d", ErrBodyTooLarge, e.Size, e.Limit)
+	case e.Decoded:
+		return fmt.Sprintf("%v: decoded body exceeds limit %d", ErrBodyTooLarge, e.Limit)
+	}
+	return fmt.Sprintf("%v: body exceeds limit %d", ErrBodyTooLarge, e.Limit)
+}
+
+func (e *BodyTooLargeError) Is(target error) bool {
+	return target == ErrBodyTooLarge
+}
+
+// 响应body最大长度接口（可选）
+//   请求实现此接口并返回大于零的值时，覆盖客户端的最大长度；返回负数时不限制
+type MaxBodySizeRequest interface {
+	MaxBodySize() int64
+}
+
+// 设置响应body最大长度（字节），为零时不限制
+//   响应头部Content-Length超过最大长度时，请求返回BodyTooLargeError（钩子的AfterRequest可以看到此错误）；
+//   没有Content-Length（如chunked）时，读取超过最大长度时resp.Truncated()为true，
+//   Bytes()、ToJSON()、ToFile()等（包括钩子读取resp.Body）返回读取的前一部分以及BodyTooLargeError。
+//   压缩的响应限制解码之后的长度，以免解压炸弹。
+//   流式响应（见IsStream()）不限制总长度
+func (c *Client) SetMaxBodySize(size int64) *Client {
+	if size < 0 {
+		size = 0
+	}
+	c.maxBodySize = size
+	return c
+}
+
+// 请求的响应body最大长度，为零时不限制
+func (c *Client) bodySizeLimit(req Request) int64 {
+	if r, ok := req.(MaxBodySizeRequest); ok && r.MaxBodySize() != 0 {
+		if r.MaxBodySize() < 0 {
+			return 0
+		}
+		return r.MaxBodySize()
+	}
+	return c.maxBodySize
+}
+
+// 检查响应头部声明的长度
+func checkContentLength(httpResp *http.Response, limit int64) error {
+	if limit <= 0 || nil == httpResp || http.NoBody == httpResp.Body || httpResp.ContentLength <= limit {
+		return nil
+	}
+	return &BodyTooLargeError{Limit: limit, Size: httpResp.ContentLength}
+}
+
+// 限制响应body的长度，读取超过最大长度时返回BodyTooLargeError并设置resp.truncated
+//   客户端已经解码的body限制的是解码之后的长度
+func limitBody(resp *Response) {
+	httpResp := resp.Response
+	if resp.maxBodySize <= 0 || resp.stream || nil == httpResp || nil == httpResp.Body || http.NoBody == httpResp.Body {
+		return
+	}
+	httpResp.Body = newLimitedBody(httpResp.Body, resp, httpResp.Uncompressed)
+}
+
+// 限制长度的body，超过时返回BodyTooLargeError
+type limitedBody struct {
+	io.ReadCloser
+	resp      *Response
+	limit     int64
+	remaining int64
+	decoded   bool
+}
+
+func newLimitedBody(body io.ReadCloser, resp *Response, decoded bool) io.ReadCloser {
+	return &limitedBody{ReadCloser: body, resp: resp, limit: resp.maxBodySize, remaining: resp.maxBodySize, decoded: decoded}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// 已经读取最大长度，再读取一个字节判断是否还有数据
+		var one [1]byte
+		n, err := b.ReadCloser.Read(one[:])
+		if n > 0 {
+			b.resp.truncated = true
+			return 0, &BodyTooLargeError{Limit: b.limit, Size: -1, Decoded: b.decoded}
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
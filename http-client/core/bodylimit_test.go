@@ -0,0 +1,141 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 响应body，chunked为true时不返回Content-Length
+func newBodyServer(body []byte, header http.Header, chunked bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		if chunked {
+			w.Write(body[:1])
+			w.(http.Flusher).Flush()
+			w.Write(body[1:])
+			return
+		}
+		w.Write(body)
+	}))
+}
+
+// 读取响应body，记录请求的错误以及body是否超过最大长度
+type bodyLimitHook struct {
+	err       error
+	readErr   error
+	truncated bool
+}
+
+func (h *bodyLimitHook) BeforeRequest(req Request, client Client) error {
+	return nil
+}
+
+func (h *bodyLimitHook) AfterRequest(cErr error, req Request, client Client) {
+	h.err = cErr
+	if resp := req.Response(); nil != resp {
+		_, h.readErr = resp.Bytes()
+		h.truncated = resp.Truncated()
+	}
+}
+
+func TestClient_MaxBodySizeContentLength(t *testing.T) {
+	ts := newBodyServer(bytes.Repeat([]byte("a"), 100), nil, false)
+	defer ts.Close()
+
+	hook := &bodyLimitHook{}
+	client := NewClientBuilder("test").MaxBodySize(50).Hooks(hook).Build()
+	_, err := client.DoRequest(&TestRequest{RequestURL: ts.URL})
+	var tooLarge *BodyTooLargeError
+	if !errors.Is(err, ErrBodyTooLarge) || !errors.As(err, &tooLarge) || tooLarge.Size != 100 || tooLarge.Limit != 50 {
+		t.Fatal("content length exceeds limit", err)
+	}
+	if !errors.Is(hook.err, ErrBodyTooLarge) {
+		t.Fatal("hook should see the error", hook.err)
+	}
+
+	// 请求覆盖客户端的最大长度
+	req := &TestRequest{RequestURL: ts.URL}
+	req.SetMaxBodySize(100)
+	if resp, err := client.DoRequest(req); nil != err || len(resp.ToString()) != 100 {
+		t.Fatal("request limit", err)
+	}
+	req.SetMaxBodySize(-1)
+	if resp, err := client.DoRequest(req); nil != err || len(resp.ToString()) != 100 {
+		t.Fatal("request without limit", err)
+	}
+}
+
+func TestClient_MaxBodySizeChunked(t *testing.T) {
+	ts := newBodyServer(bytes.Repeat([]byte("a"), 100), nil, true)
+	defer ts.Close()
+
+	hook := &bodyLimitHook{}
+	client := NewClient("test", nil).SetMaxBodySize(60).AppendHook(hook)
+	resp, err := client.DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err || !resp.Truncated() {
+		t.Fatal("DoRequest", err)
+	}
+	// 钩子读取body时可以看到
+	if nil != hook.err || !errors.Is(hook.readErr, ErrBodyTooLarge) || !hook.truncated {
+		t.Fatal("hook should see the truncation", hook)
+	}
+	data, err := resp.Bytes()
+	var tooLarge *BodyTooLargeError
+	if len(data) != 60 || !errors.As(err, &tooLarge) || tooLarge.Size != -1 || tooLarge.Decoded || !resp.Truncated() {
+		t.Fatal("body should be truncated", len(data), err)
+	}
+	if _, err = resp.Bytes(); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatal("error should be kept", err)
+	}
+	if resp.ToString() != "" {
+		t.Fatal("ToString should fail")
+	}
+
+	// 刚好等于最大长度
+	resp, _ = NewClient("test", nil).SetMaxBodySize(100).DoRequest(&TestRequest{RequestURL: ts.URL})
+	if data, err = resp.Bytes(); nil != err || len(data) != 100 || resp.Truncated() {
+		t.Fatal("body within limit", len(data), err)
+	}
+}
+
+func TestClient_MaxBodySizeDecoded(t *testing.T) {
+	// 压缩之后很小，解压之后1MB
+	body := gzipData(make([]byte, 1<<20))
+	ts := newBodyServer(body, http.Header{"Content-Encoding": {"gzip"}}, false)
+	defer ts.Close()
+
+	hook := &bodyLimitHook{}
+	resp, err := NewClient("test", nil).SetMaxBodySize(64<<10).AppendHook(hook).DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err || !errors.Is(hook.readErr, ErrBodyTooLarge) || !hook.truncated {
+		t.Fatal("DoRequest", err, hook.readErr)
+	}
+	data, err := resp.Bytes()
+	var tooLarge *BodyTooLargeError
+	if len(data) != 64<<10 || !errors.As(err, &tooLarge) || !tooLarge.Decoded || !resp.Truncated() {
+		t.Fatal("decoded size should be limited", len(data), err)
+	}
+}
+
+func TestClient_MaxBodySizeStream(t *testing.T) {
+	ts := newBodyServer([]byte(strings.Repeat("data: a\n\n", 20)), http.Header{"Content-Type": {"text/event-stream"}}, true)
+	defer ts.Close()
+
+	resp, err := NewClient("test", nil).SetMaxBodySize(10).DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	events := resp.Events()
+	count := 0
+	for _, err = events.Next(); nil == err; _, err = events.Next() {
+		count++
+	}
+	if count != 20 {
+		t.Fatal("stream should not be limited", count, err)
+	}
+}
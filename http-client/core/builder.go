@@ -0,0 +1,195 @@
+package core
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// 客户端构建器
+//   一次性设置客户端的所有配置，通过Build()构建客户端。
+//   构建出来的客户端拥有独立的http.Client（以及必要时独立的http.Transport），
+//   不会与其他客户端共享可变的配置。
+//
+// example:
+//
+//	client := core.NewClientBuilder("title").
+//		Timeout(3 * time.Second).
+//		Proxy(http.ProxyFromEnvironment).
+//		Hooks(logHook, circuitHook).
+//		Build()
+type ClientBuilder struct {
+	title            string
+	version          string
+	debug            bool
+	httpClient       *http.Client
+	transport        http.RoundTripper
+	proxy            func(*http.Request) (*url.URL, error)
+	tlsConfig        *tls.Config
+	timeout          time.Duration
+	maxBadRetryCount int
+	retryPolicy      RetryPolicy
+	fallback         FallbackFunc
+	hedgePolicy      *HedgePolicy
+	balancer         *Balancer
+	maxBodySize      int64
+	hooks            []Hook
+	ctx              Context
+}
+
+// 新建客户端构建器
+func NewClientBuilder(title string) *ClientBuilder {
+	return &ClientBuilder{
+		title:            title,
+		version:          Version,
+		maxBadRetryCount: defaultMaxBadRetryCount,
+		ctx:              BackgroundContext(),
+	}
+}
+
+func (b *ClientBuilder) Version(version string) *ClientBuilder {
+	b.version = version
+	return b
+}
+
+func (b *ClientBuilder) Debug(debug bool) *ClientBuilder {
+	b.debug = debug
+	return b
+}
+
+// 基础http.Client，构建时会复制一份，不会修改它
+func (b *ClientBuilder) HttpClient(client *http.Client) *ClientBuilder {
+	b.httpClient = client
+	return b
+}
+
+// 设置Transport，覆盖基础http.Client中的Transport
+func (b *ClientBuilder) Transport(transport http.RoundTripper) *ClientBuilder {
+	b.transport = transport
+	return b
+}
+
+// 设置代理
+// 只有Transport为*http.Transport时才生效
+func (b *ClientBuilder) Proxy(proxy func(*http.Request) (*url.URL, error)) *ClientBuilder {
+	b.proxy = proxy
+	return b
+}
+
+// 设置TLS配置
+// 只有Transport为*http.Transport时才生效
+func (b *ClientBuilder) TLSConfig(config *tls.Config) *ClientBuilder {
+	b.tlsConfig = config
+	return b
+}
+
+// 每一次尝试的超时时间
+func (b *ClientBuilder) Timeout(timeout time.Duration) *ClientBuilder {
+	b.timeout = timeout
+	return b
+}
+
+func (b *ClientBuilder) MaxBadRetryCount(retryCount int) *ClientBuilder {
+	if retryCount <= 0 {
+		retryCount = 1
+	}
+	b.maxBadRetryCount = retryCount
+	return b
+}
+
+func (b *ClientBuilder) RetryPolicy(policy RetryPolicy) *ClientBuilder {
+	b.retryPolicy = policy
+	return b
+}
+
+// 降级处理函数
+func (b *ClientBuilder) Fallback(fallback FallbackFunc) *ClientBuilder {
+	b.fallback = fallback
+	return b
+}
+
+// 设置对冲策略，见Client.SetHedgePolicy()
+// 每一次构建的客户端分别统计请求时间
+func (b *ClientBuilder) HedgePolicy(policy *HedgePolicy) *ClientBuilder {
+	b.hedgePolicy = policy
+	return b
+}
+
+// 设置负载均衡，见Client.SetBalancer()
+// 构建的客户端共享节点状态
+func (b *ClientBuilder) Balancer(balancer *Balancer) *ClientBuilder {
+	b.balancer = balancer
+	return b
+}
+
+// 设置响应body最大长度，见Client.SetMaxBodySize()
+func (b *ClientBuilder) MaxBodySize(size int64) *ClientBuilder {
+	b.maxBodySize = size
+	return b
+}
+
+// 追加钩子
+func (b *ClientBuilder) Hooks(hook ...Hook) *ClientBuilder {
+	b.hooks = append(b.hooks, hook...)
+	return b
+}
+
+func (b *ClientBuilder) Context(ctx Context) *ClientBuilder {
+	b.ctx = ctx
+	return b
+}
+
+// 构建客户端
+// 构建器可以重复使用，每一次构建的客户端互相独立
+func (b *ClientBuilder) Build() *Client {
+	httpClient := http.Client{}
+	if nil != b.httpClient {
+		httpClient = *b.httpClient
+	}
+	if nil != b.transport {
+		httpClient.Transport = b.transport
+	}
+	if nil != b.proxy || nil != b.tlsConfig {
+		if transport, ok := cloneTransport(httpClient.Transport); ok {
+			if nil != b.proxy {
+				transport.Proxy = b.proxy
+			}
+			if nil != b.tlsConfig {
+				transport.TLSClientConfig = b.tlsConfig.Clone()
+			}
+			httpClient.Transport = transport
+		}
+	}
+	ctx := b.ctx
+	if nil == ctx {
+		ctx = BackgroundContext()
+	}
+	client := &Client{
+		Client:           &httpClient,
+		hookList:         append([]Hook(nil), b.hooks...),
+		userAgent:        b.title,
+		timeout:          b.timeout,
+		maxBadRetryCount: b.maxBadRetryCount,
+		retryPolicy:      b.retryPolicy,
+		fallback:         b.fallback,
+		balancer:         b.balancer,
+		version:          b.version,
+		debug:            b.debug,
+		ctx:              ctx,
+	}
+	return client.SetMaxBodySize(b.maxBodySize).SetHedgePolicy(b.hedgePolicy)
+}
+
+// 复制Transport
+// 为nil时复制http.DefaultTransport；不是*http.Transport时无法复制
+func cloneTransport(rt http.RoundTripper) (*http.Transport, bool) {
+	if nil == rt {
+		rt = http.DefaultTransport
+	}
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		return nil, false
+	}
+	return transport.Clone(), true
+}
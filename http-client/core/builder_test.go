@@ -0,0 +1,118 @@
+package core
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientBuilder(t *testing.T) {
+	proxyURL, _ := url.Parse("http://127.0.0.1:8118")
+	builder := NewClientBuilder("test").
+		Timeout(time.Second).
+		Proxy(http.ProxyURL(proxyURL)).
+		TLSConfig(&tls.Config{InsecureSkipVerify: true}).
+		Hooks(&TestHook{})
+	c1 := builder.Build()
+	c2 := builder.Build()
+	if c1.Client == c2.Client || c1.Transport == c2.Transport {
+		t.Fatal("Build should not share http.Client")
+	}
+	if c1.Transport == http.DefaultTransport ||
+		http.DefaultTransport.(*http.Transport).TLSClientConfig == c1.Transport.(*http.Transport).TLSClientConfig {
+		t.Fatal("Build should not modify http.DefaultTransport")
+	}
+	if !c1.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify {
+		t.Fatal("TLSConfig")
+	}
+	if c1.timeout != time.Second || len(c1.hookList) != 1 {
+		t.Fatal("Build", c1.timeout, c1.hookList)
+	}
+
+	// 派生的客户端不影响原来的客户端
+	c3 := c1.Builder().Timeout(2 * time.Second).Hooks(&TestHook{}).Build()
+	if c1.timeout != time.Second || len(c1.hookList) != 1 || c3.timeout != 2*time.Second || len(c3.hookList) != 2 {
+		t.Fatal("Builder", c1.timeout, c3.timeout)
+	}
+}
+
+func TestClient_SetProxyNotShared(t *testing.T) {
+	c := NewClient("test", http.DefaultClient)
+	c.SetProxy(func(*http.Request) (*url.URL, error) { return nil, nil })
+	if c.Client == http.DefaultClient || http.DefaultClient.Transport != nil {
+		t.Fatal("SetProxy should not modify http.DefaultClient")
+	}
+	if http.DefaultTransport.(*http.Transport).Proxy == nil {
+		t.Fatal("SetProxy should not modify http.DefaultTransport")
+	}
+	if c.Transport.(*http.Transport).Proxy == nil {
+		t.Fatal("SetProxy")
+	}
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, err := time.ParseDuration(r.URL.Query().Get("sleep")); err == nil {
+			time.Sleep(d)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	hook := &countHook{}
+	fast := NewClient("fast", http.DefaultClient).SetTimeOut(20 * time.Millisecond).SetMaxBadRetryCount(1)
+	slow := NewClientBuilder("slow").HttpClient(http.DefaultClient).Timeout(time.Second).Hooks(hook).Build()
+	builder := slow.Builder()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			req := &TestRequest{RequestURL: ts.URL + "?sleep=200ms"}
+			if _, err := fast.DoRequest(req); err == nil {
+				t.Error("fast client should timeout")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			req := &TestRequest{RequestURL: ts.URL + "?sleep=50ms"}
+			resp, err := slow.DoRequest(req)
+			if err != nil || resp.ToString() != "ok" {
+				t.Error("slow client", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			c := builder.Build()
+			req := &TestRequest{RequestURL: ts.URL}
+			if _, err := c.DoRequest(req); err != nil {
+				t.Error("built client", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&hook.count) != 40 {
+		t.Fatal("hook", hook.count)
+	}
+	if http.DefaultClient.Timeout != 0 {
+		t.Fatal("http.DefaultClient.Timeout should not be modified", http.DefaultClient.Timeout)
+	}
+}
+
+type countHook struct {
+	count int32
+}
+
+func (h *countHook) BeforeRequest(req Request, client Client) error {
+	return nil
+}
+
+func (h *countHook) AfterRequest(cErr error, req Request, client Client) {
+	atomic.AddInt32(&h.count, 1)
+}
@@ -1,8 +1,10 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"time"
@@ -17,8 +19,13 @@ func init() {
 //
 //  客户端
 //  处理http请求
+//
+//  并发安全：处理请求的过程中不会修改客户端以及http.Client的任何配置，
+//  同一个客户端可以在多个goroutine中同时使用。
+//  SetXXX()系列方法只应该在初始化阶段调用，
+//  如果需要在运行时调整配置，请通过Builder()构建一个新的客户端。
 type Client struct {
-	// 采用默认&http.Client{}
+	// 采用默认http.DefaultClient
 	*http.Client
 
 	// 存放钩子对象队列数组
@@ -27,19 +34,42 @@ type Client struct {
 	// 用户
 	userAgent string
 
-	// 和http.Client.Timeout相关
+	// 每一次尝试的超时时间
+	// 通过请求上下文实现，不会修改http.Client.Timeout
+	// 为零时只受http.Client.Timeout限制
 	timeout time.Duration
 
 	// 失败尝试最大次数
 	// 默认2次
 	maxBadRetryCount int
 
+	// 重试策略
+	// 如果为nil，则根据maxBadRetryCount采用默认的指数退避策略
+	retryPolicy RetryPolicy
+
+	// 降级处理函数
+	fallback FallbackFunc
+
+	// 对冲策略以及状态
+	// 如果为nil，不对冲
+	hedger *hedger
+
+	// 负载均衡
+	// 如果为nil，按请求URL发送
+	balancer *Balancer
+
+	// 响应body最大长度
+	// 为零时不限制
+	maxBodySize int64
+
 	// 版本号
 	version string
 	// debug
 	debug bool
 
 	// 上下文
+	// 客户端级别，只在请求开始之前检查一次
+	// 请求级别的上下文请使用DoRequestContext()
 	ctx Context
 }
 
@@ -59,12 +89,17 @@ func (c *Client) SetUserAgent(userAgent string) *Client {
 }
 
 func (c *Client) AppendHook(hook ...Hook) *Client {
-	c.hookList = append(c.hookList, hook...)
+	// 复制一份，避免与其他客户端共享底层数组
+	hookList := make([]Hook, 0, len(c.hookList)+len(hook))
+	hookList = append(hookList, c.hookList...)
+	c.hookList = append(hookList, hook...)
 	return c
 }
 
+// 设置每一次尝试的超时时间
+// 不会修改共享的http.Client
 func (c *Client) SetTimeOut(timeout time.Duration) *Client {
-	c.Timeout = timeout
+	c.timeout = timeout
 	return c
 }
 
@@ -81,6 +116,20 @@ func (c *Client) SetMaxBadRetryCount(retryCount int) *Client {
 	return c
 }
 
+// 设置重试策略
+// 设置之后，SetMaxBadRetryCount()将不再起作用
+func (c *Client) SetRetryPolicy(policy RetryPolicy) *Client {
+	c.retryPolicy = policy
+	return c
+}
+
+func (c *Client) getRetryPolicy() RetryPolicy {
+	if nil != c.retryPolicy {
+		return c.retryPolicy
+	}
+	return NewRetryPolicy(c.maxBadRetryCount)
+}
+
 // 设置代理
 // example:
 //
@@ -90,76 +139,244 @@ func (c *Client) SetMaxBadRetryCount(retryCount int) *Client {
 // 	}
 //  你也可以通过设置环境变量 HTTP_PROXY 来设置代理，如：
 //      os.Setenv("HTTP_PROXY", "http://127.0.0.1:8888")
+//
+//  不会修改原来的http.Client以及http.Transport（可能被其他请求共享），
+//  而是复制一份新的替换当前客户端的http.Client。
 func (c *Client) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
-	//TODO::默认http.Client或者默认http.Transport时，是否值得改变代理（影响其他请求）？
-	if nil != c.Client && nil != c.Client.Transport {
-		c.Client.Transport.(*http.Transport).Proxy = proxy
+	transport, ok := cloneTransport(c.httpClient().Transport)
+	if !ok {
+		return
 	}
-
+	transport.Proxy = proxy
+	httpClient := *c.httpClient()
+	httpClient.Transport = transport
+	c.Client = &httpClient
 	return
 }
 
+// 返回底层的http.Client，如果没有设置，返回http.DefaultClient
+func (c *Client) httpClient() *http.Client {
+	if nil == c.Client {
+		return http.DefaultClient
+	}
+	return c.Client
+}
+
+// 构建一个包含当前客户端配置的构建器
+// 可以在不影响当前客户端的情况下，派生新的客户端
+func (c *Client) Builder() *ClientBuilder {
+	return &ClientBuilder{
+		title:            c.userAgent,
+		version:          c.version,
+		debug:            c.debug,
+		httpClient:       c.Client,
+		timeout:          c.timeout,
+		maxBadRetryCount: c.maxBadRetryCount,
+		retryPolicy:      c.retryPolicy,
+		fallback:         c.fallback,
+		hedgePolicy:      c.hedgePolicy(),
+		balancer:         c.balancer,
+		maxBodySize:      c.maxBodySize,
+		hooks:            append([]Hook(nil), c.hookList...),
+		ctx:              c.ctx,
+	}
+}
+
 // 处理请求
 //
 // 请求将有一定次数的失败重连机会。
-// 默认为2次，可以通过SetMaxBadRetryCount()设置失败重连次数.
-// 真实尝试的次数会记录在请求实体中。
+// 默认为2次，可以通过SetMaxBadRetryCount()设置失败重连次数，
+// 或者通过SetRetryPolicy()设置重试策略。
+// 每一次尝试都会通过Request.HttpRequest()重新构建请求（包括body）。
+// 真实尝试的次数以及每一次尝试的记录会保存在请求实体中。
 //
 // 记录请求处理时间。
 //
 func (c *Client) doRequest(req Request) (resp *Response, err error) {
-	if nil == c.Client {
-		c.Client = http.DefaultClient
-	}
+	httpClient := c.httpClient()
+	policy := c.getRetryPolicy()
 	t0 := time.Now()
-	req.setReqCount(0)
-	httpReq, err := req.HttpRequest()
-	if nil != err {
-		return nil, err
-	}
-	//必要头部信息设置
-	httpReq.Header.Set("User-Agent", `Bping-Curl-`+c.userAgent+"/"+c.version)
-	// 超时时间设置
-	c.Client.Timeout = c.Timeout
 	var httpResp *http.Response
 	// 尝试次数记录
 	reqCount := 0
-	for ; reqCount < c.maxBadRetryCount; reqCount++ {
-		httpResp, err = c.Client.Do(httpReq)
-		if nil == err {
+	for reqCount < policy.MaxAttempts() {
+		var httpReq *http.Request
+		httpReq, err = c.newHTTPRequest(req)
+		if nil != err {
+			if nil == httpReq {
+				return nil, err
+			}
 			break
 		}
+		req.setRawRequest(httpReq)
+		reqCount++
+		var attempts []Attempt
+		if delay, ok := c.hedgeDelay(httpReq, req); ok {
+			httpReq, httpResp, err, attempts = c.hedge(httpClient, httpReq, req, reqCount, delay)
+			req.setRawRequest(httpReq)
+		} else {
+			attempt := Attempt{Index: reqCount, Start: time.Now(), Request: httpReq}
+			httpResp, err = c.send(req.Context(), httpClient, httpReq, req)
+			attempt.LongTime = time.Since(attempt.Start)
+			attempt.Err = err
+			attempt.Timeout = IsTimeout(err)
+			if nil != httpResp {
+				attempt.StatusCode = httpResp.StatusCode
+			}
+			attempts = []Attempt{attempt}
+		}
+		if reqCount >= policy.MaxAttempts() || !policy.ShouldRetry(httpReq, httpResp, err, reqCount) {
+			addAttempts(req, attempts)
+			break
+		}
+		attempts[len(attempts)-1].Backoff = policy.Backoff(reqCount, httpResp)
+		addAttempts(req, attempts)
+		discardResponse(httpResp)
+		httpResp = nil
+		if err = sleepCtx(req.Context(), attempts[len(attempts)-1].Backoff); nil != err {
+			break
+		}
+	}
+	limit := c.bodySizeLimit(req)
+	stream := IsStream(req, httpResp)
+	if nil == err && !stream {
+		if err = checkContentLength(httpResp, limit); nil != err {
+			discardResponse(httpResp)
+		}
 	}
 	t1 := time.Now()
-	req.setReqCount(reqCount)
-	req.setReqLongTime(t1.Sub(t0))
-	resp = &Response{Response: httpResp}
+	// 中间件钩子可能多次调用，累计请求次数以及时间
+	req.setReqCount(req.ReqCount() + reqCount)
+	req.setReqLongTime(req.ReqLongTime() + t1.Sub(t0))
+	resp = &Response{Response: httpResp, source: SourceNetwork, stream: stream, maxBodySize: limit}
+	if nil == err {
+		limitBody(resp)
+	}
 	req.setResponse(resp)
 	return
 }
 
+func addAttempts(req Request, attempts []Attempt) {
+	for _, attempt := range attempts {
+		req.addAttempt(attempt)
+	}
+}
+
+// 构建一次尝试的请求，并执行钩子的BeforeSend
+// 钩子返回错误时同时返回构建的请求
+func (c *Client) newHTTPRequest(req Request) (*http.Request, error) {
+	httpReq, err := req.HttpRequest()
+	if nil != err {
+		return nil, err
+	}
+	//必要头部信息设置
+	httpReq.Header.Set("User-Agent", `Bping-Curl-`+c.userAgent+"/"+c.version)
+	setAcceptEncoding(httpReq)
+	// 请求上下文，取消或者超时将中断正在处理的请求
+	// 超时时间通过复制的上下文设置，不修改共享的http.Client
+	httpReq = httpReq.WithContext(req.Context())
+	// 负载均衡选择节点，在BeforeSend之前改写请求
+	if httpReq, err = c.balance(httpReq, req); nil != err {
+		return nil, err
+	}
+	if err = c.doBeforeSend(httpReq, req); nil != err {
+		c.balanceRelease(httpReq)
+		return httpReq, err
+	}
+	return httpReq, nil
+}
+
+// 发送一次请求，并执行钩子的AfterReceive
+// 响应的Request为钩子在BeforeSend中看到的请求，body已经按Content-Encoding解码
+func (c *Client) send(ctx context.Context, httpClient *http.Client, httpReq *http.Request, req Request) (*http.Response, error) {
+	attemptCtx, cancel := c.attemptContext(ctx, req)
+	httpResp, err := httpClient.Do(httpReq.WithContext(attemptCtx))
+	httpResp = withCancel(httpResp, cancel)
+	httpResp = c.balanceDone(httpReq, httpResp, err)
+	if nil == err {
+		httpResp.Request = httpReq
+		decodeResponse(httpResp)
+		if err = c.doAfterReceive(httpResp, req); nil != err {
+			discardResponse(httpResp)
+			httpResp = nil
+		}
+	}
+	return httpResp, err
+}
+
+// 每一次尝试的上下文
+// 请求自定义的每一次尝试超时时间优先于客户端默认的超时时间
+func (c *Client) attemptContext(ctx context.Context, req Request) (context.Context, context.CancelFunc) {
+	timeout := c.timeout
+	if r, ok := req.(AttemptTimeOutRequest); ok && r.AttemptTimeOut() > 0 {
+		timeout = r.AttemptTimeOut()
+	}
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// 整个请求（包括所有尝试以及重试等待）的上下文
+func (c *Client) requestContext(ctx context.Context, req Request) (context.Context, context.CancelFunc) {
+	if r, ok := req.(TimeOutRequest); ok && r.TimeOut() > 0 {
+		return context.WithTimeout(ctx, r.TimeOut())
+	}
+	return context.WithCancel(ctx)
+}
+
+// 响应body关闭时才取消上下文，
+// 否则在读取body之前上下文已经取消，导致读取失败
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func withCancel(httpResp *http.Response, cancel context.CancelFunc) *http.Response {
+	if nil == httpResp || nil == httpResp.Body {
+		cancel()
+		return httpResp
+	}
+	httpResp.Body = &cancelBody{ReadCloser: httpResp.Body, cancel: cancel}
+	return httpResp
+}
+
 // 请求开始处理之前的操作。
 // 钩子将在此执行，其相应的方法会被执行。
-func (c *Client) doBefore(req Request) (err error) {
+// @return hooks 已经执行BeforeRequest的钩子（包括返回错误的钩子）
+func (c *Client) doBefore(req Request) (hooks []Hook, err error) {
 	if err = c.doCtx(c.ctx); err != nil {
-		return err
+		return nil, err
 	}
-	for _, hook := range c.hookList {
+	if err = c.doCtx(req.Context()); err != nil {
+		return nil, err
+	}
+	for i, hook := range c.hookList {
 		err = hook.BeforeRequest(req, *c)
 		if nil != err {
-			break
+			return c.hookList[:i+1], err
 		}
 	}
-	return
+	return c.hookList, nil
 }
 
 // 请求处理之后的操作。
 // 钩子将在此执行，其相应的方法会被执行。
 func (c *Client) doAfter(err error, req Request) {
-	for _, hook := range c.hookList {
+	c.doAfterHooks(err, req, c.hookList)
+}
+
+// 执行指定钩子的AfterRequest
+func (c *Client) doAfterHooks(err error, req Request, hooks []Hook) {
+	for _, hook := range hooks {
 		hook.AfterRequest(err, req, *c)
 	}
-	return
 }
 
 // 处理Context
@@ -172,25 +389,90 @@ func (c *Client) doCtx(ctx Context) error {
 	}
 }
 
+// 等待一段时间，上下文取消或者超时将提前返回
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // 处理请求
+// 请求对象记录了本次处理的状态（上下文、尝试次数、响应等），
+// 不能同时用于多个DoRequest()，并发时每一个调用应该使用各自的请求对象
 func (c *Client) DoRequest(req Request) (resp *Response, err error) {
-	if err = c.doBefore(req); err != nil {
+	return c.DoRequestContext(context.Background(), req)
+}
+
+// 处理请求（带上下文）
+//
+// 上下文将附加到每一次尝试构建的*http.Request上，
+// 取消或者超时将中断正在处理的请求以及重试等待。
+// 如果请求实现了TimeOutRequest，整个请求的超时时间将附加到此上下文上。
+// 钩子可以通过req.Context()获取此上下文。
+// 与DoRequest()一样，同一个请求对象不能并发使用。
+func (c *Client) DoRequestContext(ctx context.Context, req Request) (resp *Response, err error) {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	ctx, cancel := c.requestContext(ctx, req)
+	req.setContext(ctx)
+	// 清理上一次请求的数据
+	req.setReqCount(0)
+	req.setReqLongTime(0)
+	req.setAttempts(nil)
+	req.setRawRequest(nil)
+	req.setResponse(nil)
+	c.balanceReset(req)
+	if hooks, err := c.doBefore(req); err != nil {
+		cancel()
+		// 钩子拒绝请求（如断路器打开）时尝试降级。
+		// 已经执行BeforeRequest的钩子仍会执行AfterRequest，以便释放其占用的资源
+		resp, ok := c.doFallback(err, req)
+		c.doAfterHooks(err, req, hooks)
+		if ok {
+			return resp, nil
+		}
 		return nil, err
 	}
+	defer func() {
+		// 响应body关闭之后才取消上下文
+		if nil != resp && !resp.IsFallback() {
+			resp.Response = withCancel(resp.Response, cancel)
+		} else {
+			cancel()
+		}
+	}()
 	defer func() {
 		e := recover()
 		if e != nil {
 			err = errors.New(fmt.Sprintf("%v", e))
 		}
+		// 在钩子之前处理降级，钩子可以通过req.Response().IsFallback()感知
+		if nil != err && nil == e {
+			if fallbackResp, ok := c.doFallback(err, req); ok {
+				resp = fallbackResp
+			}
+		}
 		c.doAfter(err, req)
-		if nil != err {
+		if nil != resp && resp.IsFallback() {
+			err = nil
+		} else if nil != err {
 			err = clientError(err)
 		}
 		if e != nil {
 			panic(e)
 		}
 	}()
-	resp, err = c.doRequest(req)
+	resp, err = c.roundTrip(req)
+	c.observeLatency(req, resp, err)
 	if nil != err {
 		return nil, err
 	}
@@ -221,11 +503,25 @@ func NewClientCtx(ctx Context, title string, client *http.Client) *Client {
 	}
 }
 
+// 客户端错误
+// 包装请求处理过程中的错误，可以通过errors.Is()/errors.As()判断原始错误
+type ClientError struct {
+	Err error
+}
+
+func (e *ClientError) Error() string {
+	return "Bping-Http-Client-Failure:" + e.Err.Error()
+}
+
+func (e *ClientError) Unwrap() error {
+	return e.Err
+}
+
 func clientError(err error) error {
 	if nil == err {
 		return nil
 	}
-	return errors.New("Bping-Http-Client-Failure:" + err.Error())
+	return &ClientError{Err: err}
 }
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -265,6 +561,36 @@ func SetMaxBadRetryCount(retryCount int) *Client {
 	return DefaultClient.SetMaxBadRetryCount(retryCount)
 }
 
+// 设置重试策略
+// 内部调用DefaultClient
+func SetRetryPolicy(policy RetryPolicy) *Client {
+	return DefaultClient.SetRetryPolicy(policy)
+}
+
+// 设置降级处理函数
+// 内部调用DefaultClient
+func SetFallback(fallback FallbackFunc) *Client {
+	return DefaultClient.SetFallback(fallback)
+}
+
+// 设置对冲策略
+// 内部调用DefaultClient
+func SetHedgePolicy(policy *HedgePolicy) *Client {
+	return DefaultClient.SetHedgePolicy(policy)
+}
+
+// 设置响应body最大长度
+// 内部调用DefaultClient
+func SetMaxBodySize(size int64) *Client {
+	return DefaultClient.SetMaxBodySize(size)
+}
+
+// 设置负载均衡
+// 内部调用DefaultClient，curl包的请求同样生效
+func SetBalancer(balancer *Balancer) *Client {
+	return DefaultClient.SetBalancer(balancer)
+}
+
 func SetVersion(version string) *Client {
 	return DefaultClient.SetVersion(version)
 }
@@ -281,3 +607,8 @@ func SetContext(ctx Context) *Client {
 func DoRequest(req Request) (*Response, error) {
 	return DefaultClient.DoRequest(req)
 }
+
+// 处理请求（带上下文），内部调用DefaultClient
+func DoRequestContext(ctx context.Context, req Request) (*Response, error) {
+	return DefaultClient.DoRequestContext(ctx, req)
+}
@@ -5,6 +5,10 @@ package core
 import (
 	"testing"
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
 )
 
 func TestNewClientCtx(t *testing.T) {
@@ -16,4 +20,76 @@ func TestNewClientCtx(t *testing.T) {
 	if err == nil || err.Error() != "context canceled"{
 		t.Fatal("NewClientCtx", err)
 	}
-}
\ No newline at end of file
+}
+
+type TestCtxHook struct {
+	beforeCtx context.Context
+	afterCtx  context.Context
+}
+
+func (h *TestCtxHook) BeforeRequest(req Request, client Client) error {
+	h.beforeCtx = req.Context()
+	return nil
+}
+
+func (h *TestCtxHook) AfterRequest(cErr error, req Request, client Client) {
+	h.afterCtx = req.Context()
+}
+
+func TestClient_DoRequestContext(t *testing.T) {
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(block)
+
+	type ctxKey struct{}
+	ctx, cancelFunc := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "val"))
+	hook := &TestCtxHook{}
+	client := NewClient("test", nil)
+	client.AppendHook(hook)
+	time.AfterFunc(50*time.Millisecond, cancelFunc)
+	req := &TestRequest{RequestURL: ts.URL}
+	start := time.Now()
+	_, err := client.DoRequestContext(ctx, req)
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatal("DoRequestContext", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("in-flight request should be aborted", time.Since(start))
+	}
+	if req.ReqCount() != 1 {
+		t.Fatal("canceled request should not retry", req.ReqCount())
+	}
+	if hook.beforeCtx.Value(ctxKey{}) != "val" || hook.afterCtx.Value(ctxKey{}) != "val" {
+		t.Fatal("hook context", hook.beforeCtx, hook.afterCtx)
+	}
+}
+
+func TestClient_DoRequestContextBackoff(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelFunc()
+	client := NewClient("test", nil)
+	client.SetRetryPolicy(&BackoffRetryPolicy{Attempts: 3, BaseDelay: time.Minute})
+	req := &TestRequest{RequestURL: ts.URL}
+	start := time.Now()
+	_, err := client.DoRequestContext(ctx, req)
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatal("DoRequestContext", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("backoff should be canceled", time.Since(start))
+	}
+	if req.ReqCount() != 1 {
+		t.Fatal("ReqCount", req.ReqCount())
+	}
+}
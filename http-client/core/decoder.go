@@ -0,0 +1,216 @@
+package core
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// 内容解码，返回解码之后的body
+// 关闭返回的ReadCloser时不需要关闭r
+type ContentDecoder func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	decoderMutex sync.RWMutex
+	// 按注册顺序保存，用于Accept-Encoding
+	decoderNames []string
+	decoders     = make(map[string]ContentDecoder)
+)
+
+func init() {
+	RegisterDecoder("gzip", func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	})
+	RegisterDecoder("deflate", newDeflateReader)
+}
+
+// 注册内容解码（Content-Encoding）
+//   默认支持gzip以及deflate。brotli、zstd等需要引入第三方库之后注册，如：
+//   core.RegisterDecoder("br", func(r io.Reader) (io.ReadCloser, error) {
+//   	return ioutil.NopCloser(brotli.NewReader(r)), nil
+//   })
+//   注册之后客户端通过Accept-Encoding告知服务端支持的编码。decoder为nil时取消注册。
+//   与http.Transport自动解压gzip一样，响应在返回给钩子之前解码，
+//   并删除Content-Encoding以及Content-Length头部（resp.Uncompressed为true）
+func RegisterDecoder(encoding string, decoder ContentDecoder) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	decoderMutex.Lock()
+	defer decoderMutex.Unlock()
+	names := make([]string, 0, len(decoderNames)+1)
+	for _, name := range decoderNames {
+		if name != encoding {
+			names = append(names, name)
+		}
+	}
+	if nil == decoder {
+		delete(decoders, encoding)
+	} else {
+		decoders[encoding] = decoder
+		names = append(names, encoding)
+	}
+	decoderNames = names
+}
+
+// 支持的编码，作为请求的Accept-Encoding头部
+func AcceptEncoding() string {
+	decoderMutex.RLock()
+	defer decoderMutex.RUnlock()
+	return strings.Join(decoderNames, ", ")
+}
+
+func lookupDecoder(encoding string) (ContentDecoder, bool) {
+	decoderMutex.RLock()
+	defer decoderMutex.RUnlock()
+	decoder, ok := decoders[encoding]
+	return decoder, ok
+}
+
+// deflate按规范为zlib格式，但是有些服务端直接返回原始的deflate数据
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if nil == err && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// 按Content-Encoding解码body
+//   多个编码（如 Content-Encoding: deflate, gzip）按相反的顺序解码。
+//   关闭返回的ReadCloser时同时关闭body
+func decodeBody(header http.Header, body io.ReadCloser) (io.ReadCloser, error) {
+	encodings := contentEncodings(header)
+	if 0 == len(encodings) {
+		return body, nil
+	}
+	// 空的body（如HEAD、204）不需要解码
+	br := bufio.NewReader(body)
+	if _, err := br.Peek(1); err == io.EOF {
+		return body, nil
+	}
+	closers := []io.Closer{body}
+	var reader io.Reader = br
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoder, ok := lookupDecoder(encodings[i])
+		if !ok {
+			closeAll(closers)
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encodings[i])
+		}
+		rc, err := decoder(reader)
+		if nil != err {
+			closeAll(closers)
+			return nil, err
+		}
+		closers = append(closers, rc)
+		reader = rc
+	}
+	return &decodedBody{Reader: reader, closers: closers}, nil
+}
+
+// 解码之后的body，关闭时逆序关闭解码器以及原来的body
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	return closeAll(b.closers)
+}
+
+// 响应的编码，不包括identity
+func contentEncodings(header http.Header) []string {
+	var encodings []string
+	for _, value := range header["Content-Encoding"] {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+	return encodings
+}
+
+func closeAll(closers []io.Closer) error {
+	var err error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if e := closers[i].Close(); nil != e && nil == err {
+			err = e
+		}
+	}
+	return err
+}
+
+// 没有设置Accept-Encoding时，告知服务端支持的编码
+//   设置之后http.Transport不再自动解压gzip，由decodeResponse()解码
+func setAcceptEncoding(httpReq *http.Request) {
+	if httpReq.Header.Get("Accept-Encoding") != "" {
+		return
+	}
+	if accept := AcceptEncoding(); accept != "" {
+		httpReq.Header.Set("Accept-Encoding", accept)
+	}
+}
+
+// 按Content-Encoding解码响应body，在钩子的AfterReceive之前调用
+//   包含没有注册的编码时不解码，读取body（如Bytes()）时返回ErrUnsupportedEncoding
+func decodeResponse(httpResp *http.Response) {
+	if nil == httpResp.Body || http.NoBody == httpResp.Body {
+		return
+	}
+	encodings := contentEncodings(httpResp.Header)
+	if 0 == len(encodings) {
+		return
+	}
+	for _, encoding := range encodings {
+		if _, ok := lookupDecoder(encoding); !ok {
+			return
+		}
+	}
+	header := http.Header{"Content-Encoding": httpResp.Header["Content-Encoding"]}
+	httpResp.Body = &lazyDecodedBody{header: header, body: httpResp.Body}
+	setUncompressed(httpResp)
+}
+
+// body已经解码，删除Content-Encoding以及Content-Length，以免再次解码
+func setUncompressed(httpResp *http.Response) {
+	httpResp.Header.Del("Content-Encoding")
+	httpResp.Header.Del("Content-Length")
+	httpResp.ContentLength = -1
+	httpResp.Uncompressed = true
+}
+
+// 第一次读取时才解码，解码器（如gzip）会读取头部，
+// 不应该在返回响应之前阻塞（如流式响应）
+type lazyDecodedBody struct {
+	header http.Header
+	body   io.ReadCloser
+	reader io.ReadCloser
+	err    error
+}
+
+func (b *lazyDecodedBody) Read(p []byte) (int, error) {
+	if nil == b.reader && nil == b.err {
+		b.reader, b.err = decodeBody(b.header, b.body)
+	}
+	if nil != b.err {
+		return 0, b.err
+	}
+	return b.reader.Read(p)
+}
+
+func (b *lazyDecodedBody) Close() error {
+	if nil != b.reader {
+		return b.reader.Close()
+	}
+	return b.body.Close()
+}
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipData(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func zlibData(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func flateData(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// 按 Content-Encoding 返回body，并回显请求的 Accept-Encoding
+func newEncodingServer(encoding string, body []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		if encoding != "" {
+			w.Header()["Content-Encoding"] = strings.Split(encoding, "|")
+		}
+		w.Write(body)
+	}))
+}
+
+func TestResponse_Decode(t *testing.T) {
+	data := []byte("hello world")
+	cases := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", data},
+		{"identity", data},
+		{"gzip", gzipData(data)},
+		{"GZIP", gzipData(data)},
+		{"deflate", zlibData(data)},
+		{"deflate", flateData(data)},
+		{"deflate, gzip", gzipData(zlibData(data))},
+		{"gzip|deflate", zlibData(gzipData(data))},
+	}
+	client := NewClient("test", nil)
+	for _, c := range cases {
+		ts := newEncodingServer(c.encoding, c.body)
+		resp, err := client.DoRequest(&TestRequest{RequestURL: ts.URL})
+		ts.Close()
+		if nil != err {
+			t.Fatal("DoRequest", c.encoding, err)
+		}
+		if resp.Header.Get("X-Accept-Encoding") != "gzip, deflate" {
+			t.Fatal("Accept-Encoding", resp.Header.Get("X-Accept-Encoding"))
+		}
+		if body := resp.ToString(); body != string(data) {
+			t.Fatal("decode", c.encoding, body)
+		}
+	}
+}
+
+func TestResponse_DecodeStream(t *testing.T) {
+	ts := newEncodingServer("deflate, gzip", gzipData(zlibData([]byte("a\nb\n"))))
+	defer ts.Close()
+	resp, err := NewClient("test", nil).DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	lines := resp.Lines()
+	var got []string
+	for lines.Next() {
+		got = append(got, lines.Text())
+	}
+	if strings.Join(got, ",") != "a,b" || nil != lines.Err() {
+		t.Fatal("stacked encoding stream", got, lines.Err())
+	}
+}
+
+func TestResponse_DecodeRawBody(t *testing.T) {
+	data := []byte("hello world")
+	ts := newEncodingServer("gzip", gzipData(data))
+	defer ts.Close()
+	resp, err := NewClient("test", nil).DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	// 与http.Transport自动解压一样，直接读取*http.Response的body得到解码之后的内容
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Length") != "" || resp.ContentLength != -1 || !resp.Uncompressed {
+		t.Fatal("encoding headers should be removed", resp.Header, resp.ContentLength)
+	}
+	if body, err := ioutil.ReadAll(resp.Response.Body); nil != err || string(body) != string(data) {
+		t.Fatal("raw body should be decoded", string(body), err)
+	}
+
+	// Bytes()解码之后替换body，同样删除编码头部
+	resp = NewResponse(200, http.Header{"Content-Encoding": {"gzip"}}, gzipData(data))
+	resp.body = nil
+	if body, err := resp.Bytes(); nil != err || string(body) != string(data) {
+		t.Fatal("Bytes", string(body), err)
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal("Content-Encoding should be removed after Bytes()", resp.Header)
+	}
+	if body, err := ioutil.ReadAll(resp.Response.Body); nil != err || string(body) != string(data) {
+		t.Fatal("body after Bytes()", string(body), err)
+	}
+}
+
+func TestResponse_DecodeEmptyBody(t *testing.T) {
+	resp := NewResponse(204, http.Header{"Content-Encoding": {"gzip"}}, nil)
+	resp.body = nil
+	if data, err := resp.Bytes(); nil != err || len(data) != 0 {
+		t.Fatal("empty body should not be decoded", data, err)
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	// 模拟第三方解码：反转字节
+	reverse := func(r io.Reader) (io.ReadCloser, error) {
+		data, err := ioutil.ReadAll(r)
+		for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+			data[i], data[j] = data[j], data[i]
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), err
+	}
+	ts := newEncodingServer("x-reverse, gzip", gzipData([]byte("olleh")))
+	defer ts.Close()
+	client := NewClient("test", nil)
+
+	resp, err := client.DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	if _, err = resp.Bytes(); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Fatal("unregistered encoding", err)
+	}
+
+	RegisterDecoder("X-Reverse", reverse)
+	defer RegisterDecoder("x-reverse", nil)
+	resp, err = client.DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err || resp.ToString() != "hello" {
+		t.Fatal("registered decoder", err)
+	}
+	if resp.Header.Get("X-Accept-Encoding") != "gzip, deflate, x-reverse" {
+		t.Fatal("Accept-Encoding", resp.Header.Get("X-Accept-Encoding"))
+	}
+
+	// 请求设置的Accept-Encoding优先
+	req := &headerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, header: http.Header{"Accept-Encoding": {"gzip"}}}
+	if resp, err = client.DoRequest(req); nil != err || resp.Header.Get("X-Accept-Encoding") != "gzip" {
+		t.Fatal("request Accept-Encoding", err)
+	}
+}
+
+type headerRequest struct {
+	TestRequest
+	header http.Header
+}
+
+func (r *headerRequest) HttpRequest() (*http.Request, error) {
+	httpReq, err := r.TestRequest.HttpRequest()
+	if nil == err {
+		for k, v := range r.header {
+			httpReq.Header[k] = v
+		}
+	}
+	return httpReq, err
+}
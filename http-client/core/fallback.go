@@ -0,0 +1,77 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// 降级处理函数
+//   请求失败（包括钩子拒绝请求，如断路器打开）时调用。
+//   返回ok为true时，以返回的响应代替错误返回给调用者，
+//   响应将被标记为降级响应（Response.IsFallback()）。
+// @params err 请求失败的原始错误
+type FallbackFunc func(err error, req Request) (resp *Response, ok bool)
+
+// 降级钩子（可选接口）
+//   钩子实现此接口时，请求失败后将按钩子顺序调用，第一个返回ok为true的响应将被采用。
+//   所有钩子都没有提供降级响应时，再调用客户端的降级处理函数（Client.SetFallback()）。
+type FallbackHook interface {
+	Fallback(err error, req Request, client Client) (resp *Response, ok bool)
+}
+
+// 新建响应
+// 用于构建降级响应等不经过网络请求的响应
+func NewResponse(statusCode int, header http.Header, body []byte) *Response {
+	if nil == header {
+		header = make(http.Header)
+	}
+	if nil == body {
+		body = []byte{}
+	}
+	return &Response{
+		Response: &http.Response{
+			Status:        strconv.Itoa(statusCode) + " " + http.StatusText(statusCode),
+			StatusCode:    statusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+		},
+		body: body,
+	}
+}
+
+// 设置降级处理函数
+func (c *Client) SetFallback(fallback FallbackFunc) *Client {
+	c.fallback = fallback
+	return c
+}
+
+// 处理降级
+// 先按顺序询问钩子，再调用客户端的降级处理函数
+func (c *Client) doFallback(err error, req Request) (*Response, bool) {
+	var resp *Response
+	ok := false
+	for _, hook := range c.hookList {
+		if fallbackHook, is := hook.(FallbackHook); is {
+			if resp, ok = fallbackHook.Fallback(err, req, *c); ok {
+				break
+			}
+		}
+	}
+	if !ok && nil != c.fallback {
+		resp, ok = c.fallback(err, req)
+	}
+	if !ok || nil == resp {
+		return nil, false
+	}
+	// 复制一份再标记，避免修改钩子缓存的响应
+	fallbackResp := *resp
+	fallbackResp.source = SourceFallback
+	req.setResponse(&fallbackResp)
+	return &fallbackResp, true
+}
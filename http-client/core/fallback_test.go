@@ -0,0 +1,83 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+type TestFallbackHook struct {
+	TestHook
+	called   bool
+	cErr     error
+	fallback bool
+}
+
+func (h *TestFallbackHook) Fallback(err error, req Request, client Client) (*Response, bool) {
+	h.called = true
+	return nil, false
+}
+
+func (h *TestFallbackHook) AfterRequest(cErr error, req Request, client Client) {
+	h.cErr = cErr
+	h.fallback = req.Response() != nil && req.Response().IsFallback()
+}
+
+func TestClient_FallbackRejected(t *testing.T) {
+	hook := &TestFallbackHook{}
+	client := NewClient("test", nil).AppendHook(hook)
+	client.SetFallback(func(err error, req Request) (*Response, bool) {
+		return NewResponse(http.StatusOK, nil, []byte("fallback:"+err.Error())), true
+	})
+	req := &TestRequest{RequestURL: "http://127.0.0.1:1/"}
+	resp, err := client.DoRequest(req)
+	if nil != err {
+		t.Fatal("Fallback", err)
+	}
+	if !resp.IsFallback() || resp.Source() != SourceFallback || resp.StatusCode != http.StatusOK || resp.ToString() != "fallback:some error happen" {
+		t.Fatal("Fallback response", resp.StatusCode, resp.ToString())
+	}
+	if !hook.called || !hook.fallback || hook.cErr == nil || req.Response() != resp {
+		t.Fatal("hook should see fallback", hook)
+	}
+}
+
+func TestClient_FallbackFailure(t *testing.T) {
+	hook := &countHook{}
+	client := NewClient("test", nil).AppendHook(hook).SetMaxBadRetryCount(1)
+	req := &TestRequest{RequestURL: "http://127.0.0.1:1/"}
+	if _, err := client.DoRequest(req); err == nil {
+		t.Fatal("no fallback should return error")
+	}
+
+	client.SetFallback(func(err error, req Request) (*Response, bool) {
+		if errors.Is(err, errNoFallback) {
+			return nil, false
+		}
+		return NewResponse(http.StatusServiceUnavailable, http.Header{"X-Fallback": {"1"}}, nil), true
+	})
+	resp, err := client.DoRequest(req)
+	if nil != err || !resp.IsFallback() || resp.Header.Get("X-Fallback") != "1" {
+		t.Fatal("Fallback", err)
+	}
+	if req.ReqCount() != 1 || hook.count != 2 {
+		t.Fatal("request should be sent before fallback", req.ReqCount(), hook.count)
+	}
+	if new(Response).IsFallback() {
+		t.Fatal("IsFallback")
+	}
+}
+
+var errNoFallback = errors.New("no fallback")
+
+func TestClient_RejectedAfterRequest(t *testing.T) {
+	hook := &TestFallbackHook{}
+	client := NewClient("test", nil).AppendHook(hook)
+	req := &TestRequest{RequestURL: "http://127.0.0.1:1/"}
+	if _, err := client.DoRequest(req); nil == err {
+		t.Fatal("request should be rejected")
+	}
+	if hook.cErr == nil || hook.fallback || req.ReqCount() != 0 {
+		t.Fatal("AfterRequest should run for rejected request", hook.cErr, req.ReqCount())
+	}
+}
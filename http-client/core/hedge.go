@@ -0,0 +1,280 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrHedgeLost = errors.New("hedged request cancelled: another request won")
+
+const (
+	// 默认按p95请求时间对冲
+	defaultHedgePercentile = 0.95
+	// 默认对冲请求最多占10%
+	defaultHedgeMaxPercent = 0.1
+	// 默认至少20个样本才按请求时间对冲
+	defaultHedgeMinSamples = 20
+	// 默认最小对冲延迟
+	defaultHedgeMinDelay = 10 * time.Millisecond
+	// 每个服务保存的请求时间样本数
+	hedgeSampleSize = 128
+	// 对冲预算上限，即最多允许连续对冲的次数
+	maxHedgeBudget = 10
+)
+
+// 对冲请求接口（可选）
+//   请求实现此接口并返回true时，才可能对冲。BaseRequest通过SetHedge()设置
+type HedgeRequest interface {
+	Hedge() bool
+}
+
+// 对冲策略
+//
+// Delay 固定的对冲延迟：请求发出之后超过此时间还没有响应，发出一个相同的请求。
+//       如果为零，按ServerName()统计的请求时间（ReqLongTime()）的Percentile分位数计算
+//
+// Percentile 按请求时间对冲时的分位数。如果为零，默认为0.95
+//
+// MinSamples 按请求时间对冲时至少需要的样本数，样本不足时不对冲。如果为零，默认为20
+//
+// MinDelay 按请求时间对冲时的最小延迟。如果为零，默认为10毫秒
+//
+// MaxPercent 对冲请求占请求的最大比例。如果为零，默认为0.1
+type HedgePolicy struct {
+	Delay      time.Duration
+	Percentile float64
+	MinSamples int
+	MinDelay   time.Duration
+	MaxPercent float64
+}
+
+// 对冲状态，客户端复制时共享
+type hedger struct {
+	policy HedgePolicy
+
+	mutex   sync.Mutex
+	budget  float64
+	samples map[string]*latencySamples
+}
+
+// 最近的请求时间样本
+type latencySamples struct {
+	values []time.Duration
+	next   int
+}
+
+func newHedger(policy HedgePolicy) *hedger {
+	if policy.Percentile <= 0 || policy.Percentile >= 1 {
+		policy.Percentile = defaultHedgePercentile
+	}
+	if policy.MinSamples <= 0 {
+		policy.MinSamples = defaultHedgeMinSamples
+	}
+	if policy.MinDelay <= 0 {
+		policy.MinDelay = defaultHedgeMinDelay
+	}
+	if policy.MaxPercent <= 0 {
+		policy.MaxPercent = defaultHedgeMaxPercent
+	}
+	return &hedger{policy: policy, budget: 1, samples: make(map[string]*latencySamples)}
+}
+
+// 对冲延迟，不能对冲时返回false
+func (h *hedger) delay(server string) (time.Duration, bool) {
+	if h.policy.Delay > 0 {
+		return h.policy.Delay, true
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s, ok := h.samples[server]
+	if !ok || len(s.values) < h.policy.MinSamples {
+		return 0, false
+	}
+	values := append([]time.Duration(nil), s.values...)
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	d := values[int(float64(len(values)-1)*h.policy.Percentile)]
+	if d < h.policy.MinDelay {
+		d = h.policy.MinDelay
+	}
+	return d, true
+}
+
+// 每一次可以对冲的尝试增加MaxPercent的预算，对冲一次消耗1
+func (h *hedger) earn() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.budget += h.policy.MaxPercent
+	if h.budget > maxHedgeBudget {
+		h.budget = maxHedgeBudget
+	}
+}
+
+func (h *hedger) spend() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.budget < 1 {
+		return false
+	}
+	h.budget--
+	return true
+}
+
+// 记录成功请求的请求时间
+func (h *hedger) observe(server string, d time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s, ok := h.samples[server]
+	if !ok {
+		s = &latencySamples{}
+		h.samples[server] = s
+	}
+	if len(s.values) < hedgeSampleSize {
+		s.values = append(s.values, d)
+		return
+	}
+	s.values[s.next] = d
+	s.next = (s.next + 1) % hedgeSampleSize
+}
+
+// 设置对冲策略
+//   只有请求通过HedgeRequest开启对冲、并且请求方法幂等时才对冲：
+//   一次尝试发出之后超过对冲延迟还没有响应时，发出一个相同的请求（重新调用HttpRequest()以及BeforeSend()），
+//   采用最先成功（没有错误并且不是5xx）的响应，取消另外一个。
+//   两个请求都记录在Attempts()中，Index相同，Attempt.Hedge标记对冲的请求，Attempt.Won标记采用的请求。
+//   对冲不算作重试，不增加ReqCount()。
+//   对冲时TransportHook的AfterReceive可能被并发调用。
+//   为nil时不对冲。
+func (c *Client) SetHedgePolicy(policy *HedgePolicy) *Client {
+	if nil == policy {
+		c.hedger = nil
+		return c
+	}
+	c.hedger = newHedger(*policy)
+	return c
+}
+
+func (c *Client) hedgePolicy() *HedgePolicy {
+	if nil == c.hedger {
+		return nil
+	}
+	policy := c.hedger.policy
+	return &policy
+}
+
+// 对冲延迟，不对冲时返回false
+func (c *Client) hedgeDelay(httpReq *http.Request, req Request) (time.Duration, bool) {
+	if nil == c.hedger || !isIdempotent(httpReq) {
+		return 0, false
+	}
+	if r, ok := req.(HedgeRequest); !ok || !r.Hedge() {
+		return 0, false
+	}
+	c.hedger.earn()
+	return c.hedger.delay(req.ServerName())
+}
+
+// 记录成功请求的请求时间，用于计算对冲延迟
+func (c *Client) observeLatency(req Request, resp *Response, err error) {
+	if nil == c.hedger || nil != err || nil == resp || resp.source != SourceNetwork || nil == resp.Response {
+		return
+	}
+	if resp.StatusCode >= 500 {
+		return
+	}
+	c.hedger.observe(req.ServerName(), req.ReqLongTime())
+}
+
+// 对冲的一个请求
+type hedgeLeg struct {
+	index    int
+	httpReq  *http.Request
+	httpResp *http.Response
+	err      error
+	attempt  Attempt
+}
+
+// 发送一次尝试，超过delay还没有响应时对冲
+// @return httpReq 采用的请求
+// @return attempts 尝试记录，对冲时有两个
+func (c *Client) hedge(httpClient *http.Client, httpReq *http.Request, req Request, index int, delay time.Duration) (*http.Request, *http.Response, error, []Attempt) {
+	results := make(chan *hedgeLeg, 2)
+	var cancels []context.CancelFunc
+	var legs []*hedgeLeg
+	launch := func(r *http.Request) {
+		ctx, cancel := context.WithCancel(req.Context())
+		leg := &hedgeLeg{index: len(legs), httpReq: r, attempt: Attempt{Index: index, Start: time.Now(), Hedge: len(legs) > 0, Request: r}}
+		cancels = append(cancels, cancel)
+		legs = append(legs, leg)
+		go func() {
+			leg.httpResp, leg.err = c.send(ctx, httpClient, leg.httpReq, req)
+			results <- leg
+		}()
+	}
+	launch(httpReq)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	timerC := timer.C
+	var winner *hedgeLeg
+	for inflight := 1; inflight > 0; {
+		select {
+		case <-timerC:
+			timerC = nil
+			if !c.hedger.spend() {
+				continue
+			}
+			hedgeReq, err := c.newHTTPRequest(req)
+			if nil != err {
+				continue
+			}
+			launch(hedgeReq)
+			inflight++
+		case leg := <-results:
+			inflight--
+			leg.attempt.LongTime = time.Since(leg.attempt.Start)
+			leg.attempt.Err = leg.err
+			leg.attempt.Timeout = IsTimeout(leg.err)
+			if nil != leg.httpResp {
+				leg.attempt.StatusCode = leg.httpResp.StatusCode
+			}
+			if nil != winner {
+				// 已经有采用的响应，另外一个被取消
+				discardResponse(leg.httpResp)
+				leg.attempt.Err = ErrHedgeLost
+				continue
+			}
+			if hedgeSucceeded(leg) || 0 == inflight {
+				winner = leg
+				timerC = nil
+				for i, cancel := range cancels {
+					if i != leg.index {
+						cancel()
+					}
+				}
+				continue
+			}
+			// 失败的响应，等待另外一个
+			discardResponse(leg.httpResp)
+		}
+	}
+
+	attempts := make([]Attempt, 0, len(legs))
+	for _, leg := range legs {
+		if len(legs) > 1 && leg == winner {
+			leg.attempt.Won = true
+		}
+		attempts = append(attempts, leg.attempt)
+	}
+	// 响应body关闭时才取消采用的请求
+	winner.httpResp = withCancel(winner.httpResp, cancels[winner.index])
+	return winner.httpReq, winner.httpResp, winner.err, attempts
+}
+
+// 没有错误并且不是5xx
+func hedgeSucceeded(leg *hedgeLeg) bool {
+	return nil == leg.err && nil != leg.httpResp && leg.httpResp.StatusCode < 500
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 第一个请求很慢，之后的请求马上返回
+func newHedgeServer(slow time.Duration) (*httptest.Server, *int32) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		if n == 1 {
+			select {
+			case <-time.After(slow):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Write([]byte(strconv.Itoa(int(n))))
+	}))
+	return ts, &count
+}
+
+func TestClient_Hedge(t *testing.T) {
+	ts, count := newHedgeServer(2 * time.Second)
+	defer ts.Close()
+
+	client := NewClient("test", nil).SetHedgePolicy(&HedgePolicy{Delay: 20 * time.Millisecond, MaxPercent: 1})
+	req := &TestRequest{RequestURL: ts.URL}
+	req.SetHedge(true)
+	start := time.Now()
+	resp, err := client.DoRequest(req)
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	if resp.ToString() != "2" || time.Since(start) > time.Second {
+		t.Fatal("hedged request should win", resp.ToString(), time.Since(start))
+	}
+	attempts := req.Attempts()
+	if req.ReqCount() != 1 || len(attempts) != 2 {
+		t.Fatal("attempts", req.ReqCount(), attempts)
+	}
+	if attempts[0].Hedge || attempts[0].Won || attempts[0].Err != ErrHedgeLost || attempts[0].Index != 1 {
+		t.Fatal("primary attempt should lose", attempts[0])
+	}
+	if !attempts[1].Hedge || !attempts[1].Won || attempts[1].StatusCode != 200 || attempts[1].Index != 1 {
+		t.Fatal("hedge attempt should win", attempts[1])
+	}
+
+	// 没有开启对冲的请求
+	atomic.StoreInt32(count, 0)
+	ts2, _ := newHedgeServer(100 * time.Millisecond)
+	defer ts2.Close()
+	req = &TestRequest{RequestURL: ts2.URL}
+	resp, err = client.DoRequest(req)
+	if nil != err || resp.ToString() != "1" || len(req.Attempts()) != 1 {
+		t.Fatal("request should not be hedged", err, req.Attempts())
+	}
+}
+
+func TestClient_HedgeFastResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client := NewClient("test", nil).SetHedgePolicy(&HedgePolicy{Delay: time.Second, MaxPercent: 1})
+	req := &TestRequest{RequestURL: ts.URL}
+	req.SetHedge(true)
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.ToString() != "ok" || len(req.Attempts()) != 1 || req.Attempts()[0].Won {
+		t.Fatal("fast response should not be hedged", err, req.Attempts())
+	}
+}
+
+func TestClient_HedgeBudget(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(30 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	// 最多10%的对冲请求，开始时可以对冲一次
+	client := NewClient("test", nil).SetHedgePolicy(&HedgePolicy{Delay: time.Millisecond, MaxPercent: 0.1})
+	for i := 0; i < 5; i++ {
+		req := &TestRequest{RequestURL: ts.URL}
+		req.SetHedge(true)
+		if _, err := client.DoRequest(req); nil != err {
+			t.Fatal("DoRequest", err)
+		}
+	}
+	if n := atomic.LoadInt32(&count); n != 6 {
+		t.Fatal("hedged requests should be capped", n)
+	}
+}
+
+func TestHedger_Delay(t *testing.T) {
+	h := newHedger(HedgePolicy{MinSamples: 10, MinDelay: time.Millisecond})
+	if _, ok := h.delay("test"); ok {
+		t.Fatal("should not hedge without samples")
+	}
+	for i := 1; i <= 100; i++ {
+		h.observe("test", time.Duration(i)*time.Millisecond)
+	}
+	if d, ok := h.delay("test"); !ok || d != 95*time.Millisecond {
+		t.Fatal("p95", d, ok)
+	}
+	if _, ok := h.delay("other"); ok {
+		t.Fatal("samples should be kept per server")
+	}
+
+	// 只保留最近的样本
+	for i := 0; i < hedgeSampleSize; i++ {
+		h.observe("test", time.Microsecond)
+	}
+	if d, _ := h.delay("test"); d != time.Millisecond {
+		t.Fatal("MinDelay", d)
+	}
+}
@@ -5,7 +5,10 @@ type Hook interface {
 	// 请求处理前执行
 	// 如果返回错误
 	// 将提前终止请求
-	// 并将此错误返回
+	// 并将此错误返回。
+	// 此时请求没有发送（ReqCount()为0），之后的钩子不再执行；
+	// 包括此钩子在内已经执行BeforeRequest的钩子仍会执行AfterRequest，
+	// 以便释放BeforeRequest中占用的资源
 	BeforeRequest(req Request, client Client) error
 
 	// 请求处理后执行
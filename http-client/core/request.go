@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -8,6 +9,7 @@ import (
 )
 
 // 请求接口
+//   请求对象保存每一次处理的状态，可以重复使用，但是不能同时用于多个DoRequest()
 type Request interface {
 	//返回*http.Request
 	HttpRequest() (*http.Request, error)
@@ -26,6 +28,21 @@ type Request interface {
 	setReqCount(reqCount int)
 	ReqCount() (int)
 
+	// 每一次尝试的记录
+	setAttempts(attempts []Attempt)
+	addAttempt(attempt Attempt)
+	Attempts() []Attempt
+
+	// 最后一次尝试发送的*http.Request
+	// 钩子可以通过它获取请求方法、URL等信息，请不要修改它
+	setRawRequest(httpReq *http.Request)
+	RawRequest() *http.Request
+
+	// 请求上下文
+	// 由DoRequestContext()设置，钩子可以通过它感知取消以及超时
+	setContext(ctx context.Context)
+	Context() context.Context
+
 	// 钩子存放获取数据
 	SetHookData(key string, data interface{}) (ok bool)
 	HookData(key string) (data interface{}, ok bool)
@@ -44,6 +61,19 @@ type Request interface {
 type BaseRequest struct {
 	reqCount    int
 	reqLongTime time.Duration
+	attempts    []Attempt
+	ctx         context.Context
+	rawRequest  *http.Request
+
+	// 整个请求的超时时间以及每一次尝试的超时时间
+	timeout        time.Duration
+	attemptTimeout time.Duration
+	// 是否允许对冲
+	hedge bool
+	// 是否为流式请求
+	stream bool
+	// 响应body最大长度
+	maxBodySize int64
 	Resp        *Response
 
 	// 钩子存放数据Map
@@ -63,8 +93,58 @@ func (b *BaseRequest) String() string {
 	return fmt.Sprintf("\n ReqCount:%d \n", b.reqCount)
 }
 
+// 整个请求（包括所有尝试以及重试等待）的超时时间
+// 小于等于零代表不限制
 func (b *BaseRequest) TimeOut() time.Duration {
-	return -1
+	if b.timeout <= 0 {
+		return -1
+	}
+	return b.timeout
+}
+
+func (b *BaseRequest) SetTimeOut(timeout time.Duration) {
+	b.timeout = timeout
+}
+
+// 每一次尝试的超时时间，覆盖客户端默认的超时时间
+// 小于等于零代表使用客户端默认的超时时间
+func (b *BaseRequest) AttemptTimeOut() time.Duration {
+	if b.attemptTimeout <= 0 {
+		return -1
+	}
+	return b.attemptTimeout
+}
+
+func (b *BaseRequest) SetAttemptTimeOut(timeout time.Duration) {
+	b.attemptTimeout = timeout
+}
+
+// 是否允许对冲，客户端设置了对冲策略时才起作用
+// 只应该对幂等并且对延迟敏感的请求开启
+func (b *BaseRequest) Hedge() bool {
+	return b.hedge
+}
+
+func (b *BaseRequest) SetHedge(hedge bool) {
+	b.hedge = hedge
+}
+
+// 是否为流式请求，见StreamRequest
+func (b *BaseRequest) Stream() bool {
+	return b.stream
+}
+
+func (b *BaseRequest) SetStream(stream bool) {
+	b.stream = stream
+}
+
+// 响应body最大长度，见MaxBodySizeRequest
+func (b *BaseRequest) MaxBodySize() int64 {
+	return b.maxBodySize
+}
+
+func (b *BaseRequest) SetMaxBodySize(size int64) {
+	b.maxBodySize = size
 }
 
 func (b *BaseRequest) Clone() interface{} {
@@ -80,6 +160,39 @@ func (b *BaseRequest) ReqCount() int {
 	return b.reqCount
 }
 
+func (b *BaseRequest) setAttempts(attempts []Attempt) {
+	b.attempts = attempts
+}
+
+func (b *BaseRequest) addAttempt(attempt Attempt) {
+	b.attempts = append(b.attempts, attempt)
+}
+
+// 返回每一次尝试的记录（处理时间、状态码以及错误信息）
+func (b *BaseRequest) Attempts() []Attempt {
+	return b.attempts
+}
+
+func (b *BaseRequest) setRawRequest(httpReq *http.Request) {
+	b.rawRequest = httpReq
+}
+
+func (b *BaseRequest) RawRequest() *http.Request {
+	return b.rawRequest
+}
+
+func (b *BaseRequest) setContext(ctx context.Context) {
+	b.ctx = ctx
+}
+
+// 返回请求上下文，如果没有设置，返回context.Background()
+func (b *BaseRequest) Context() context.Context {
+	if nil == b.ctx {
+		return context.Background()
+	}
+	return b.ctx
+}
+
 // 设置请求处理时间
 func (b *BaseRequest) setReqLongTime(long time.Duration) {
 	b.reqLongTime = long
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// 默认每5秒检查一次文件
+const defaultResolverInterval = 5 * time.Second
+
+// 服务发现接口
+//   返回逻辑服务名对应的节点列表，节点为 host:port 或者 scheme://host:port。
+//   ok为false时，不是需要负载均衡的服务，请求按原来的URL发送
+type Resolver interface {
+	Resolve(service string) (endpoints []string, ok bool)
+}
+
+// 固定的服务节点
+type StaticResolver map[string][]string
+
+func (r StaticResolver) Resolve(service string) ([]string, bool) {
+	endpoints, ok := r[service]
+	return endpoints, ok
+}
+
+// 从文件读取服务节点
+//   文件为JSON格式，如 {"user-service": ["10.0.0.1:8080", "https://10.0.0.2:8443"]}。
+//   定时检查文件修改时间，修改之后重新加载；加载失败时保留之前的节点列表
+type FileResolver struct {
+	path     string
+	interval time.Duration
+
+	mutex    sync.RWMutex
+	services map[string][]string
+	modTime  time.Time
+	err      error
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// 新建文件服务发现
+// @params interval 检查文件的间隔。如果为零，默认为5秒
+// 第一次加载失败时返回错误
+func NewFileResolver(path string, interval time.Duration) (*FileResolver, error) {
+	if interval <= 0 {
+		interval = defaultResolverInterval
+	}
+	r := &FileResolver{path: path, interval: interval, done: make(chan struct{})}
+	if err := r.Reload(); nil != err {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+func (r *FileResolver) Resolve(service string) ([]string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	endpoints, ok := r.services[service]
+	return endpoints, ok
+}
+
+// 重新加载文件
+func (r *FileResolver) Reload() error {
+	info, err := os.Stat(r.path)
+	if nil == err {
+		var data []byte
+		if data, err = ioutil.ReadFile(r.path); nil == err {
+			services := make(map[string][]string)
+			if err = json.Unmarshal(data, &services); nil == err {
+				r.mutex.Lock()
+				r.services, r.modTime, r.err = services, info.ModTime(), nil
+				r.mutex.Unlock()
+				return nil
+			}
+		}
+	}
+	r.mutex.Lock()
+	r.err = err
+	r.mutex.Unlock()
+	return err
+}
+
+// 最近一次加载的错误
+func (r *FileResolver) Err() error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.err
+}
+
+// 停止检查文件
+func (r *FileResolver) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return nil
+}
+
+func (r *FileResolver) watch() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			r.mutex.RLock()
+			changed := nil != err || !info.ModTime().Equal(r.modTime)
+			r.mutex.RUnlock()
+			if changed {
+				r.Reload()
+			}
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package core
 
 import (
-	"compress/gzip"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
@@ -29,6 +28,41 @@ var (
 type Response struct {
 	*http.Response
 	body []byte //缓存响应的Response的body字节内容
+
+	// 响应来源
+	source ResponseSource
+
+	// 是否为流式请求的响应
+	stream bool
+
+	// body最大长度，为零时不限制
+	maxBodySize int64
+	// body超过最大长度，只读取了一部分
+	truncated bool
+	// 读取body超过最大长度的错误
+	bodyErr error
+}
+
+// 响应来源
+type ResponseSource string
+
+const (
+	// 网络请求的响应
+	SourceNetwork = ResponseSource("network")
+	// 钩子直接提供的响应（如缓存命中、模拟响应），没有发送请求
+	SourceHook = ResponseSource("hook")
+	// 降级响应（请求失败之后由降级处理提供）
+	SourceFallback = ResponseSource("fallback")
+)
+
+// 响应来源。NewResponse()新建、还没有返回给调用者的响应为空
+func (resp *Response) Source() ResponseSource {
+	return resp.source
+}
+
+// 是否为降级响应
+func (resp *Response) IsFallback() bool {
+	return resp.source == SourceFallback
 }
 
 // 响应的Response的body字节内容保存到文件中去(文件请求)
@@ -42,15 +76,19 @@ func (resp *Response) ToFile(filename string) error {
 	if resp.Response == nil || resp.Response.Body == nil {
 		return nil
 	}
-	defer resp.Response.Body.Close()
-	_, err = io.Copy(f, resp.Response.Body)
+	reader, err := resp.reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(f, reader)
 	return err
 }
 
 // 返回响应的Response的body字节内容
 func (resp *Response) Bytes() ([]byte, error) {
 	if resp.body != nil {
-		return resp.body, nil
+		return resp.body, resp.bodyErr
 	}
 	if resp.Response == nil {
 		return nil, RawRespNilErr
@@ -58,22 +96,66 @@ func (resp *Response) Bytes() ([]byte, error) {
 	if resp.Response.Body == nil {
 		return nil, RawRespBodyNilErr
 	}
-	var err error
-	defer resp.Response.Body.Close()
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		reader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		resp.body, err = ioutil.ReadAll(reader)
-	} else {
-		resp.body, err = ioutil.ReadAll(resp.Body)
+	encoded := len(contentEncodings(resp.Header)) > 0
+	reader, err := resp.reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	resp.body, err = ioutil.ReadAll(reader)
+	if errors.Is(err, ErrBodyTooLarge) {
+		resp.bodyErr = err
 	}
 	// 为了*http.Response能再次使用，重新复制回去
 	resp.Response.Body = ioutil.NopCloser(bytes.NewReader(resp.body))
+	if encoded {
+		setUncompressed(resp.Response)
+	}
 	return resp.body, err
 }
 
+// 返回按Content-Encoding解码之后的body，关闭时同时关闭原来的body
+// 已经通过Bytes()读取时，返回读取的内容
+// 客户端返回的body已经解码并限制长度（见Client.SetMaxBodySize()）
+func (resp *Response) reader() (io.ReadCloser, error) {
+	if resp.body != nil {
+		return ioutil.NopCloser(bytes.NewReader(resp.body)), nil
+	}
+	if resp.Response == nil {
+		return nil, RawRespNilErr
+	}
+	if resp.Response.Body == nil {
+		return nil, RawRespBodyNilErr
+	}
+	return decodeBody(resp.Header, resp.Body)
+}
+
+// body是否超过最大长度（见Client.SetMaxBodySize()），只读取了一部分
+func (resp *Response) Truncated() bool {
+	return resp.truncated
+}
+
+// 读取body的前max个字节，返回读取的内容以及放回读取内容之后的body
+// body超过max个字节时truncated为true。钩子记录body（如日志）时使用，不影响之后读取完整的body
+func PeekBody(body io.ReadCloser, max int) (rc io.ReadCloser, data []byte, truncated bool) {
+	if nil == body || http.NoBody == body {
+		return body, nil, false
+	}
+	buf := make([]byte, max+1)
+	n, _ := io.ReadFull(body, buf)
+	rc = &peekedBody{Reader: io.MultiReader(bytes.NewReader(buf[:n]), body), Closer: body}
+	if n > max {
+		return rc, buf[:max], true
+	}
+	return rc, buf[:n], false
+}
+
+// 已经读取一部分的body，读取时先返回已经读取的部分
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
 // 将响应的Response的body字节内容以JSON格式转化
 func (resp *Response) ToJSON(v interface{}) error {
 	data, err := resp.Bytes()
@@ -102,7 +184,12 @@ func (resp *Response) ToString() string {
 	return string(data)
 }
 
+// 关闭响应body
+// 没有读取body（如只检查StatusCode）时需要关闭，以便释放连接、请求上下文以及负载均衡的处理中请求数。
+// 已经通过Bytes()等读取的body不受影响
 func (resp *Response) Close() error {
-	//resp.body = nil
-	return nil
+	if nil == resp.Response || nil == resp.Response.Body {
+		return nil
+	}
+	return resp.Response.Body.Close()
 }
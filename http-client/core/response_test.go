@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"bytes"
 	"os"
+	"path/filepath"
 )
 
 type tmp struct {
@@ -46,11 +47,13 @@ func TestResponse(t *testing.T) {
 		t.Fatal("ToXML", tmpVal.Name)
 	}
 
-	resp.ToFile("cbping.xml")
-	xmlFile, err := os.Open("cbping.xml")
+	filename := filepath.Join(t.TempDir(), "cbping.xml")
+	resp.ToFile(filename)
+	xmlFile, err := os.Open(filename)
 	if err != nil {
 		t.Fatal("ToFile", tmpVal.Name)
 	}
+	defer xmlFile.Close()
 
 	str := make([]byte, 1024)
 	xmlFile.Read(str)
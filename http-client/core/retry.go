@@ -0,0 +1,212 @@
+package core
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 重试策略接口
+//   决定一次尝试之后是否需要重试，以及重试之前需要等待多长时间
+type RetryPolicy interface {
+	// 最大尝试次数（包括第一次请求）
+	MaxAttempts() int
+
+	// 第attempt次（从1开始）尝试之后是否需要重试
+	// @params httpReq  本次尝试的请求
+	// @params httpResp 本次尝试的响应，请求失败时为nil
+	// @params err      本次尝试的错误信息
+	ShouldRetry(httpReq *http.Request, httpResp *http.Response, err error, attempt int) bool
+
+	// 第attempt次尝试之后，下一次尝试之前的等待时间
+	Backoff(attempt int, httpResp *http.Response) time.Duration
+}
+
+// 请求尝试记录
+type Attempt struct {
+	// 第几次尝试，从1开始
+	Index int
+	// 开始时间
+	Start time.Time
+	// 处理时间
+	LongTime time.Duration
+	// 响应状态码，请求失败时为0
+	StatusCode int
+	// 错误信息
+	Err error
+	// 是否因为超时而失败
+	Timeout bool
+	// 本次尝试之后，重试之前等待的时间
+	Backoff time.Duration
+	// 是否为对冲请求，即同一次尝试中延迟发出的相同请求（见SetHedgePolicy）
+	Hedge bool
+	// 对冲时，是否为采用的请求；没有采用的请求Err为ErrHedgeLost
+	Won bool
+	// 发送的请求，即传输钩子在BeforeSend中看到的请求，钩子可以据此对应每一次尝试
+	Request *http.Request
+}
+
+// 指数退避重试策略
+//
+// Attempts 最大尝试次数（包括第一次请求）。如果小于等于零，默认为2次
+//
+// BaseDelay 第一次重试之前的等待时间，之后每次翻倍。如果为零，默认为100毫秒
+//
+// MaxDelay 等待时间上限（包括Retry-After指定的时间）。如果为零，默认为10秒
+//
+// Jitter 抖动比例（0~1），等待时间在 [delay*(1-Jitter), delay] 之间随机
+//
+// RetryStatusCodes 需要重试的响应状态码。如果为nil，默认为 429、502、503、504
+//
+// RetryNonIdempotent 是否重试非幂等方法（POST、PATCH等）。
+//                    默认不重试，除非请求带有 Idempotency-Key 头部
+//
+type BackoffRetryPolicy struct {
+	Attempts           int
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+	Jitter             float64
+	RetryStatusCodes   []int
+	RetryNonIdempotent bool
+}
+
+const (
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 10 * time.Second
+	defaultRetryJitter    = 0.2
+)
+
+var defaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// 新建指数退避重试策略
+func NewRetryPolicy(attempts int) *BackoffRetryPolicy {
+	return &BackoffRetryPolicy{
+		Attempts:  attempts,
+		BaseDelay: defaultRetryBaseDelay,
+		MaxDelay:  defaultRetryMaxDelay,
+		Jitter:    defaultRetryJitter,
+	}
+}
+
+func (p *BackoffRetryPolicy) MaxAttempts() int {
+	if p.Attempts <= 0 {
+		return defaultMaxBadRetryCount
+	}
+	return p.Attempts
+}
+
+func (p *BackoffRetryPolicy) ShouldRetry(httpReq *http.Request, httpResp *http.Response, err error, attempt int) bool {
+	if attempt >= p.MaxAttempts() {
+		return false
+	}
+	if !p.RetryNonIdempotent && !isIdempotent(httpReq) {
+		return false
+	}
+	// 上下文已取消或者超时，不再重试
+	if nil != httpReq && nil != httpReq.Context().Err() {
+		return false
+	}
+	if nil != err {
+		return true
+	}
+	if nil == httpResp {
+		return false
+	}
+	codes := p.RetryStatusCodes
+	if nil == codes {
+		codes = defaultRetryStatusCodes
+	}
+	for _, code := range codes {
+		if httpResp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *BackoffRetryPolicy) Backoff(attempt int, httpResp *http.Response) time.Duration {
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+	// 优先使用服务端指定的等待时间
+	if wait, ok := RetryAfter(httpResp, time.Now()); ok {
+		if wait > maxDelay {
+			wait = maxDelay
+		}
+		return wait
+	}
+	delay := p.BaseDelay
+	if delay <= 0 {
+		delay = defaultRetryBaseDelay
+	}
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+	}
+	return delay
+}
+
+// 解析响应的Retry-After头部，支持秒数以及HTTP日期两种格式
+// @return wait 需要等待的时间，HTTP日期已经过去时为零
+func RetryAfter(httpResp *http.Response, now time.Time) (time.Duration, bool) {
+	if nil == httpResp {
+		return 0, false
+	}
+	val := httpResp.Header.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(val); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		wait := t.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// 判断请求是否幂等
+func isIdempotent(httpReq *http.Request) bool {
+	if nil == httpReq {
+		return false
+	}
+	switch httpReq.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return httpReq.Header.Get("Idempotency-Key") != ""
+}
+
+// 丢弃将要重试的响应，以便连接可以复用
+func discardResponse(httpResp *http.Response) {
+	if nil == httpResp || nil == httpResp.Body {
+		return
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(httpResp.Body, 4096))
+	httpResp.Body.Close()
+}
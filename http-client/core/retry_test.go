@@ -0,0 +1,135 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type TestBodyRequest struct {
+	BaseRequest
+	Method     string
+	RequestURL string
+	Body       string
+}
+
+func (b *TestBodyRequest) HttpRequest() (*http.Request, error) {
+	return http.NewRequest(b.Method, b.RequestURL, strings.NewReader(b.Body))
+}
+
+func TestClient_RetryStatus(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client := NewClient("test", nil)
+	client.SetRetryPolicy(&BackoffRetryPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+	req := &TestRequest{RequestURL: ts.URL}
+	resp, err := client.DoRequest(req)
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	if resp.ToString() != "ok" {
+		t.Fatal("Response", resp.ToString())
+	}
+	if req.ReqCount() != 3 || len(req.Attempts()) != 3 {
+		t.Fatal("ReqCount", req.ReqCount(), len(req.Attempts()))
+	}
+	attempts := req.Attempts()
+	if attempts[0].StatusCode != http.StatusServiceUnavailable || attempts[2].StatusCode != http.StatusOK {
+		t.Fatal("Attempts", attempts)
+	}
+	if attempts[0].Backoff <= 0 || attempts[2].Backoff != 0 {
+		t.Fatal("Backoff", attempts)
+	}
+}
+
+func TestClient_RetryRebuildBody(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Error("body", string(body))
+		}
+		if atomic.AddInt32(&count, 1) < 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient("test", nil)
+	client.SetRetryPolicy(&BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+	req := &TestBodyRequest{Method: http.MethodPut, RequestURL: ts.URL, Body: "payload"}
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.StatusCode != http.StatusOK {
+		t.Fatal("DoRequest", err)
+	}
+	if atomic.LoadInt32(&count) != 2 {
+		t.Fatal("count", count)
+	}
+}
+
+func TestClient_RetryNonIdempotent(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := NewClient("test", nil)
+	client.SetRetryPolicy(&BackoffRetryPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+	req := &TestBodyRequest{Method: http.MethodPost, RequestURL: ts.URL, Body: "payload"}
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("DoRequest", err)
+	}
+	if req.ReqCount() != 1 || atomic.LoadInt32(&count) != 1 {
+		t.Fatal("POST should not retry", req.ReqCount())
+	}
+}
+
+func TestBackoffRetryPolicy_Backoff(t *testing.T) {
+	policy := &BackoffRetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	if d := policy.Backoff(1, nil); d != 10*time.Millisecond {
+		t.Fatal("Backoff 1", d)
+	}
+	if d := policy.Backoff(2, nil); d != 20*time.Millisecond {
+		t.Fatal("Backoff 2", d)
+	}
+	if d := policy.Backoff(10, nil); d != 50*time.Millisecond {
+		t.Fatal("Backoff MaxDelay", d)
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		if d := policy.Backoff(1, nil); d < 5*time.Millisecond || d > 10*time.Millisecond {
+			t.Fatal("Backoff Jitter", d)
+		}
+	}
+
+	httpResp := &http.Response{Header: http.Header{}}
+	httpResp.Header.Set("Retry-After", "0")
+	if d := policy.Backoff(1, httpResp); d != 0 {
+		t.Fatal("Retry-After seconds", d)
+	}
+	httpResp.Header.Set("Retry-After", "120")
+	if d := policy.Backoff(1, httpResp); d != 50*time.Millisecond {
+		t.Fatal("Retry-After MaxDelay", d)
+	}
+	httpResp.Header.Set("Retry-After", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	if d := policy.Backoff(1, httpResp); d != 0 {
+		t.Fatal("Retry-After date", d)
+	}
+}
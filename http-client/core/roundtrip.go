@@ -0,0 +1,65 @@
+package core
+
+// 请求处理函数
+//   处理请求（包括重试）并返回响应
+type RoundTripFunc func(req Request) (*Response, error)
+
+// 中间件钩子（可选接口）
+//   钩子实现此接口时，RoundTrip将包裹请求的处理，按钩子顺序由外到内执行，
+//   在所有钩子的BeforeRequest之后、AfterRequest之前调用。
+//   可以不调用next而直接返回响应（如缓存命中），也可以修改next返回的响应。
+//   调用next时可以传入包装之后的请求（如添加条件请求头部），
+//   包装的请求应该内嵌原来的请求，以便记录请求次数、响应等信息。
+//   多次调用next（如令牌失效之后重试）时，请求次数、请求时间以及尝试记录累计。
+type RoundTripHook interface {
+	RoundTrip(req Request, client Client, next RoundTripFunc) (*Response, error)
+}
+
+// 按钩子顺序组装请求处理链
+func (c *Client) roundTrip(req Request) (*Response, error) {
+	next := RoundTripFunc(c.doRequest)
+	for i := len(c.hookList) - 1; i >= 0; i-- {
+		if hook, ok := c.hookList[i].(RoundTripHook); ok {
+			next = c.roundTripNext(hook, next)
+		}
+	}
+	resp, err := next(req)
+	if nil != resp {
+		// 钩子可能返回了另外的响应
+		if resp.source == "" {
+			resp.source = SourceHook
+		}
+		req.setResponse(resp)
+	}
+	return resp, err
+}
+
+// 中间件钩子函数适配器
+//   BeforeRequest以及AfterRequest不做任何处理
+//
+// 例子：
+//
+//	client.AppendHook(core.RoundTripHookFunc(func(req core.Request, client core.Client, next core.RoundTripFunc) (*core.Response, error) {
+//		if mock, ok := mocks[req.ServerName()]; ok {
+//			return core.NewResponse(200, nil, mock), nil
+//		}
+//		return next(req)
+//	}))
+type RoundTripHookFunc func(req Request, client Client, next RoundTripFunc) (*Response, error)
+
+func (f RoundTripHookFunc) BeforeRequest(req Request, client Client) error {
+	return nil
+}
+
+func (f RoundTripHookFunc) AfterRequest(cErr error, req Request, client Client) {
+}
+
+func (f RoundTripHookFunc) RoundTrip(req Request, client Client, next RoundTripFunc) (*Response, error) {
+	return f(req, client, next)
+}
+
+func (c *Client) roundTripNext(hook RoundTripHook, next RoundTripFunc) RoundTripFunc {
+	return func(req Request) (*Response, error) {
+		return hook.RoundTrip(req, *c, next)
+	}
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 记录执行顺序的中间件钩子
+type roundTripHook struct {
+	name  string
+	trace *[]string
+	// 不为nil时直接返回此响应
+	resp *Response
+}
+
+func (h *roundTripHook) BeforeRequest(req Request, client Client) error { return nil }
+
+func (h *roundTripHook) AfterRequest(cErr error, req Request, client Client) {}
+
+func (h *roundTripHook) RoundTrip(req Request, client Client, next RoundTripFunc) (*Response, error) {
+	*h.trace = append(*h.trace, h.name)
+	if nil != h.resp {
+		return h.resp, nil
+	}
+	resp, err := next(req)
+	if nil == err {
+		resp.Header.Set("X-"+h.name, "1")
+	}
+	return resp, err
+}
+
+func TestClient_RoundTripHook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("network"))
+	}))
+	defer ts.Close()
+
+	var trace []string
+	outer := &roundTripHook{name: "Outer", trace: &trace}
+	inner := &roundTripHook{name: "Inner", trace: &trace}
+	client := NewClient("test", nil).AppendHook(outer, inner)
+	req := &TestRequest{RequestURL: ts.URL}
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.ToString() != "network" {
+		t.Fatal("DoRequest", err)
+	}
+	if len(trace) != 2 || trace[0] != "Outer" || trace[1] != "Inner" {
+		t.Fatal("order", trace)
+	}
+	if resp.Header.Get("X-Outer") != "1" || resp.Header.Get("X-Inner") != "1" || req.ReqCount() != 1 {
+		t.Fatal("response should be wrapped", resp.Header)
+	}
+
+	// 短路，不发送请求
+	trace = nil
+	inner.resp = NewResponse(http.StatusOK, nil, []byte("cached"))
+	resp, err = client.DoRequest(req)
+	if nil != err || resp.ToString() != "cached" || req.Response() != resp {
+		t.Fatal("short circuit", err)
+	}
+	if req.ReqCount() != 0 || resp.Header.Get("X-Outer") != "1" {
+		t.Fatal("short circuit should not send request", req.ReqCount())
+	}
+}
+
+func TestRoundTripHookFunc(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("network"))
+	}))
+	defer ts.Close()
+
+	client := NewClient("test", nil).AppendHook(RoundTripHookFunc(func(req Request, client Client, next RoundTripFunc) (*Response, error) {
+		if req.(*TestRequest).RequestURL == "mock" {
+			return NewResponse(http.StatusOK, nil, []byte("mock")), nil
+		}
+		return next(req)
+	}))
+	resp, err := client.DoRequest(&TestRequest{RequestURL: "mock"})
+	if nil != err || resp.ToString() != "mock" || resp.Source() != SourceHook || resp.IsFallback() {
+		t.Fatal("mock", err, resp.Source())
+	}
+	resp, err = client.DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err || resp.ToString() != "network" || resp.Source() != SourceNetwork {
+		t.Fatal("network", err, resp.Source())
+	}
+}
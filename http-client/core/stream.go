@@ -0,0 +1,292 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 流式读取时一行的最大长度
+const maxStreamLineSize = 1 << 20
+
+var ErrNotJSONArray = errors.New("response body is not a JSON array")
+
+// 流式的响应类型
+var streamContentTypes = map[string]bool{
+	"text/event-stream":       true,
+	"application/x-ndjson":    true,
+	"application/jsonl":       true,
+	"application/stream+json": true,
+	"application/json-seq":    true,
+}
+
+// 流式请求接口（可选）
+//   请求实现此接口并返回true时，响应作为流式响应处理，BaseRequest通过SetStream()设置。
+//   流式响应的body应该通过Lines()、NDJSON()、Events()、JSONArray()边读边处理，
+//   钩子不应该读取（缓冲）流式响应的body
+type StreamRequest interface {
+	Stream() bool
+}
+
+// 是否为流式响应：请求开启了流式，或者响应类型为 text/event-stream、application/x-ndjson 等
+// 供钩子在返回响应之前判断（如TransportHook的AfterReceive）
+func IsStream(req Request, httpResp *http.Response) bool {
+	if r, ok := req.(StreamRequest); ok && r.Stream() {
+		return true
+	}
+	return nil != httpResp && isStreamContentType(httpResp.Header)
+}
+
+func isStreamContentType(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return nil == err && streamContentTypes[mediaType]
+}
+
+// 是否为流式响应，见IsStream()
+func (resp *Response) IsStream() bool {
+	return resp.stream || (nil != resp.Response && isStreamContentType(resp.Header))
+}
+
+// 按行读取响应body
+//   body读取完或者出错之后自动关闭，提前结束时需要调用Close()
+//   for lines.Next() { lines.Text() }; lines.Err()
+type LineReader struct {
+	scanner *bufio.Scanner
+	closer  io.Closer
+	err     error
+}
+
+// 按行读取响应body，不缓冲整个body
+func (resp *Response) Lines() *LineReader {
+	reader, err := resp.reader()
+	if nil != err {
+		return &LineReader{err: err}
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 4096), maxStreamLineSize)
+	return &LineReader{scanner: scanner, closer: reader}
+}
+
+// 读取下一行，没有更多的行或者出错时返回false
+func (lr *LineReader) Next() bool {
+	if nil == lr.scanner {
+		return false
+	}
+	if lr.scanner.Scan() {
+		return true
+	}
+	lr.err = lr.scanner.Err()
+	lr.Close()
+	return false
+}
+
+// 当前行，不包括换行符
+func (lr *LineReader) Text() string {
+	return lr.scanner.Text()
+}
+
+// 当前行，下一次调用Next()之后失效
+func (lr *LineReader) Bytes() []byte {
+	return lr.scanner.Bytes()
+}
+
+// 读取过程中的错误，正常读取完为nil
+func (lr *LineReader) Err() error {
+	return lr.err
+}
+
+func (lr *LineReader) Close() error {
+	lr.scanner = nil
+	if nil == lr.closer {
+		return nil
+	}
+	closer := lr.closer
+	lr.closer = nil
+	return closer.Close()
+}
+
+// NDJSON（每一行一个JSON）解码
+type NDJSONDecoder struct {
+	lines *LineReader
+}
+
+// 按NDJSON格式读取响应body
+func (resp *Response) NDJSON() *NDJSONDecoder {
+	return &NDJSONDecoder{lines: resp.Lines()}
+}
+
+// 解码下一行，跳过空行；读取完返回io.EOF
+func (d *NDJSONDecoder) Decode(v interface{}) error {
+	for d.lines.Next() {
+		line := bytes.TrimSpace(d.lines.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		return json.Unmarshal(line, v)
+	}
+	if err := d.lines.Err(); nil != err {
+		return err
+	}
+	return io.EOF
+}
+
+func (d *NDJSONDecoder) Close() error {
+	return d.lines.Close()
+}
+
+// Server-Sent Events事件
+//
+// ID 事件ID，没有时为最近一次的事件ID
+//
+// Event 事件类型，默认为message
+//
+// Data 数据，多行数据以换行符连接
+//
+// Retry 服务端建议的重连时间，没有时为零
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// Server-Sent Events读取
+type EventReader struct {
+	lines       *LineReader
+	lastEventID string
+	first       bool
+}
+
+// 按Server-Sent Events（text/event-stream）格式读取响应body
+func (resp *Response) Events() *EventReader {
+	return &EventReader{lines: resp.Lines(), first: true}
+}
+
+// 读取下一个事件，读取完返回io.EOF
+// 没有以空行结束的最后一个事件被丢弃
+func (er *EventReader) Next() (*Event, error) {
+	event := &Event{}
+	var data []string
+	for er.lines.Next() {
+		line := er.lines.Text()
+		if er.first {
+			line = strings.TrimPrefix(line, "\ufeff")
+			er.first = false
+		}
+		if line == "" {
+			if nil == data {
+				// 没有数据的事件不分发
+				event = &Event{}
+				continue
+			}
+			event.ID = er.lastEventID
+			event.Data = strings.Join(data, "\n")
+			if event.Event == "" {
+				event.Event = "message"
+			}
+			return event, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			// 注释
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				er.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); nil == err {
+				event.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := er.lines.Err(); nil != err {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// 最近一次的事件ID，重连时作为 Last-Event-ID 头部
+func (er *EventReader) LastEventID() string {
+	return er.lastEventID
+}
+
+func (er *EventReader) Close() error {
+	return er.lines.Close()
+}
+
+// JSON数组逐个元素解码
+type JSONArrayDecoder struct {
+	decoder *json.Decoder
+	closer  io.Closer
+	err     error
+	started bool
+}
+
+// 逐个元素读取响应body中的JSON数组，不缓冲整个数组
+func (resp *Response) JSONArray() *JSONArrayDecoder {
+	reader, err := resp.reader()
+	if nil != err {
+		return &JSONArrayDecoder{err: err}
+	}
+	return &JSONArrayDecoder{decoder: json.NewDecoder(reader), closer: reader}
+}
+
+// 解码下一个元素，读取完返回io.EOF
+func (d *JSONArrayDecoder) Decode(v interface{}) error {
+	if nil != d.err {
+		return d.err
+	}
+	if !d.started {
+		d.started = true
+		token, err := d.decoder.Token()
+		if nil != err {
+			return d.fail(err)
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return d.fail(ErrNotJSONArray)
+		}
+	}
+	if !d.decoder.More() {
+		if _, err := d.decoder.Token(); nil != err {
+			return d.fail(err)
+		}
+		return d.fail(io.EOF)
+	}
+	if err := d.decoder.Decode(v); nil != err {
+		return d.fail(err)
+	}
+	return nil
+}
+
+// 结束读取，之后总是返回err
+func (d *JSONArrayDecoder) fail(err error) error {
+	d.err = err
+	d.Close()
+	return err
+}
+
+func (d *JSONArrayDecoder) Close() error {
+	if nil == d.closer {
+		return nil
+	}
+	closer := d.closer
+	d.closer = nil
+	return closer.Close()
+}
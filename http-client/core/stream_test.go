@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 先发送first，等待next之后再发送rest
+func newStreamServer(contentType, first, rest string, next chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(first))
+		w.(http.Flusher).Flush()
+		select {
+		case <-next:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(rest))
+	}))
+}
+
+func TestResponse_Lines(t *testing.T) {
+	next := make(chan struct{})
+	ts := newStreamServer("text/plain", "first\r\n", "second\n\nthird", next)
+	defer ts.Close()
+
+	req := &TestRequest{RequestURL: ts.URL}
+	req.SetStream(true)
+	resp, err := NewClient("test", nil).DoRequest(req)
+	if nil != err || !resp.IsStream() {
+		t.Fatal("DoRequest", err, resp.IsStream())
+	}
+	lines := resp.Lines()
+	// 没有读取完整个body之前就可以读取第一行
+	if !lines.Next() || lines.Text() != "first" {
+		t.Fatal("first line", lines.Err())
+	}
+	close(next)
+	var got []string
+	for lines.Next() {
+		got = append(got, lines.Text())
+	}
+	if nil != lines.Err() || len(got) != 3 || got[0] != "second" || got[1] != "" || got[2] != "third" {
+		t.Fatal("lines", got, lines.Err())
+	}
+}
+
+func TestResponse_NDJSON(t *testing.T) {
+	next := make(chan struct{})
+	ts := newStreamServer("application/x-ndjson; charset=utf-8", `{"id":1}`+"\n", "\n"+`{"id":2}`+"\n"+`{"id":`, next)
+	defer ts.Close()
+
+	resp, err := NewClient("test", nil).DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err || !resp.IsStream() {
+		t.Fatal("content type should be recognized as stream", err)
+	}
+	decoder := resp.NDJSON()
+	defer decoder.Close()
+	var v struct{ ID int }
+	if err = decoder.Decode(&v); nil != err || v.ID != 1 {
+		t.Fatal("first item", err, v)
+	}
+	close(next)
+	if err = decoder.Decode(&v); nil != err || v.ID != 2 {
+		t.Fatal("second item", err, v)
+	}
+	if err = decoder.Decode(&v); nil == err || err == io.EOF {
+		t.Fatal("truncated item should fail", err)
+	}
+}
+
+func TestResponse_Events(t *testing.T) {
+	next := make(chan struct{})
+	body := "\ufeff: comment\nevent: update\nid: 1\ndata: a\ndata:b\n\n"
+	rest := "retry: 3000\nid\n\ndata: c\n\nid: 9\ndata: incomplete"
+	ts := newStreamServer("text/event-stream", body, rest, next)
+	defer ts.Close()
+
+	resp, err := NewClient("test", nil).DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	events := resp.Events()
+	defer events.Close()
+	event, err := events.Next()
+	if nil != err || event.Event != "update" || event.ID != "1" || event.Data != "a\nb" {
+		t.Fatal("first event", err, event)
+	}
+	close(next)
+	// 没有数据的事件不分发，但是id以及retry仍然生效
+	event, err = events.Next()
+	if nil != err || event.Event != "message" || event.ID != "" || event.Data != "c" {
+		t.Fatal("second event", err, event)
+	}
+	if _, err = events.Next(); err != io.EOF || events.LastEventID() != "9" {
+		t.Fatal("incomplete event should be dropped", err, events.LastEventID())
+	}
+}
+
+func TestResponse_JSONArray(t *testing.T) {
+	next := make(chan struct{})
+	ts := newStreamServer("application/json", `[{"id":1},`, ` {"id":2}]`, next)
+	defer ts.Close()
+
+	resp, err := NewClient("test", nil).DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err || resp.IsStream() {
+		t.Fatal("DoRequest", err, resp.IsStream())
+	}
+	decoder := resp.JSONArray()
+	var v struct{ ID int }
+	if err = decoder.Decode(&v); nil != err || v.ID != 1 {
+		t.Fatal("first item", err, v)
+	}
+	close(next)
+	if err = decoder.Decode(&v); nil != err || v.ID != 2 {
+		t.Fatal("second item", err, v)
+	}
+	if err = decoder.Decode(&v); err != io.EOF {
+		t.Fatal("end of array", err)
+	}
+
+	if err = NewResponse(200, nil, []byte(`{"id":1}`)).JSONArray().Decode(&v); err != ErrNotJSONArray {
+		t.Fatal("not array", err)
+	}
+	decoder = NewResponse(200, nil, []byte(`[{"id":1}`)).JSONArray()
+	decoder.Decode(&v)
+	if err = decoder.Decode(&v); nil == err || err == io.EOF {
+		t.Fatal("unterminated array should fail", err)
+	}
+}
+
+func TestResponse_StreamGzip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write([]byte("a\nb\n"))
+		gw.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	client := NewClient("test", &http.Client{Transport: &http.Transport{DisableCompression: true}, Timeout: 5 * time.Second})
+	resp, err := client.DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	lines := resp.Lines()
+	var got []string
+	for lines.Next() {
+		got = append(got, lines.Text())
+	}
+	if len(got) != 2 || got[1] != "b" {
+		t.Fatal("gzip lines", got, lines.Err())
+	}
+}
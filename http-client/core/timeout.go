@@ -0,0 +1,38 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// 请求超时接口（可选）
+//   请求实现此接口并返回大于零的值时，
+//   整个请求（包括所有尝试以及重试等待）将在此时间之后超时
+type TimeOutRequest interface {
+	TimeOut() time.Duration
+}
+
+// 请求尝试超时接口（可选）
+//   请求实现此接口并返回大于零的值时，
+//   每一次尝试的超时时间将覆盖客户端默认的超时时间
+type AttemptTimeOutRequest interface {
+	AttemptTimeOut() time.Duration
+}
+
+// 判断错误是否由超时引起
+// 钩子可以通过它判断请求失败是否因为超时
+func IsTimeout(err error) bool {
+	if nil == err {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
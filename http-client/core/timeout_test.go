@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type TestTimeoutHook struct {
+	timeout bool
+}
+
+func (h *TestTimeoutHook) BeforeRequest(req Request, client Client) error {
+	return nil
+}
+
+func (h *TestTimeoutHook) AfterRequest(cErr error, req Request, client Client) {
+	h.timeout = IsTimeout(cErr)
+}
+
+func TestClient_AttemptTimeOut(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client := NewClient("test", nil).SetTimeOut(time.Minute)
+	client.SetRetryPolicy(&BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+	req := &TestRequest{RequestURL: ts.URL}
+	req.SetAttemptTimeOut(50 * time.Millisecond)
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.ToString() != "ok" {
+		t.Fatal("DoRequest", err)
+	}
+	attempts := req.Attempts()
+	if len(attempts) != 2 || !attempts[0].Timeout || attempts[1].Timeout {
+		t.Fatal("Attempts", attempts)
+	}
+}
+
+func TestClient_TimeOut(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	hook := &TestTimeoutHook{}
+	client := NewClient("test", nil).AppendHook(hook)
+	client.SetRetryPolicy(&BackoffRetryPolicy{Attempts: 5, BaseDelay: time.Millisecond})
+	req := &TestRequest{RequestURL: ts.URL}
+	req.SetTimeOut(50 * time.Millisecond)
+	start := time.Now()
+	_, err := client.DoRequest(req)
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("TimeOut", time.Since(start))
+	}
+	if !IsTimeout(err) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("IsTimeout", err)
+	}
+	if _, ok := err.(*ClientError); !ok {
+		t.Fatal("ClientError", err)
+	}
+	if !hook.timeout {
+		t.Fatal("hook should see timeout")
+	}
+	if req.ReqCount() != 1 {
+		t.Fatal("ReqCount", req.ReqCount())
+	}
+}
+
+func TestClient_TimeOutReadBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client := NewClient("test", nil).SetTimeOut(time.Second)
+	req := &TestRequest{RequestURL: ts.URL}
+	req.SetTimeOut(time.Second)
+	resp, err := client.DoRequest(req)
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	// 请求返回之后，body依然可以读取
+	if resp.ToString() != "ok" {
+		t.Fatal("ToString", resp.ToString())
+	}
+	if IsTimeout(nil) || IsTimeout(errors.New("some error happen")) {
+		t.Fatal("IsTimeout")
+	}
+}
@@ -0,0 +1,40 @@
+package core
+
+import "net/http"
+
+// 传输钩子（可选接口）
+//   钩子实现此接口时，每一次尝试（包括重试）都会调用，
+//   可以直接修改将要发送的请求以及刚收到的响应，如添加头部、签名、改写URL等。
+//   BeforeSend按钩子顺序调用，返回错误时终止请求；
+//   AfterReceive按钩子逆序调用，只在请求成功时调用，
+//   返回错误时本次尝试视为失败，由重试策略决定是否重试。
+//   httpResp.Request为BeforeSend中的httpReq，可以据此对应请求以及响应。
+//   对冲（见SetHedgePolicy）时两个请求的AfterReceive可能并发调用，而且不一定按发送顺序。
+type TransportHook interface {
+	BeforeSend(httpReq *http.Request, req Request) error
+	AfterReceive(httpResp *http.Response, req Request) error
+}
+
+// 发送请求之前调用传输钩子
+func (c *Client) doBeforeSend(httpReq *http.Request, req Request) error {
+	for _, hook := range c.hookList {
+		if transportHook, ok := hook.(TransportHook); ok {
+			if err := transportHook.BeforeSend(httpReq, req); nil != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 收到响应之后调用传输钩子
+func (c *Client) doAfterReceive(httpResp *http.Response, req Request) error {
+	for i := len(c.hookList) - 1; i >= 0; i-- {
+		if transportHook, ok := c.hookList[i].(TransportHook); ok {
+			if err := transportHook.AfterReceive(httpResp, req); nil != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type transportHook struct {
+	trace     []string
+	rejectErr error
+}
+
+func (h *transportHook) BeforeRequest(req Request, client Client) error { return nil }
+
+func (h *transportHook) AfterRequest(cErr error, req Request, client Client) {}
+
+func (h *transportHook) BeforeSend(httpReq *http.Request, req Request) error {
+	h.trace = append(h.trace, "BeforeSend")
+	httpReq.Header.Set("X-Attempt", httpReq.Header.Get("X-Attempt")+"1")
+	httpReq.URL.Path = "/rewritten"
+	return nil
+}
+
+func (h *transportHook) AfterReceive(httpResp *http.Response, req Request) error {
+	h.trace = append(h.trace, "AfterReceive")
+	return h.rejectErr
+}
+
+func TestClient_TransportHook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + ":" + r.Header.Get("X-Attempt")))
+	}))
+	defer ts.Close()
+
+	hook := &transportHook{}
+	client := NewClient("test", nil).AppendHook(hook)
+	req := &TestRequest{RequestURL: ts.URL}
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.ToString() != "/rewritten:1" {
+		t.Fatal("DoRequest", err, resp.ToString())
+	}
+	if req.RawRequest().URL.Path != "/rewritten" || len(hook.trace) != 2 {
+		t.Fatal("RawRequest", req.RawRequest().URL, hook.trace)
+	}
+
+	// AfterReceive返回错误时按失败重试
+	hook.trace = nil
+	hook.rejectErr = errors.New("invalid response")
+	client.SetRetryPolicy(&BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+	if _, err = client.DoRequest(req); nil == err || !errors.Is(err, hook.rejectErr) {
+		t.Fatal("AfterReceive error", err)
+	}
+	if req.ReqCount() != 2 || len(hook.trace) != 4 {
+		t.Fatal("should retry", req.ReqCount(), hook.trace)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 const (
@@ -110,6 +111,20 @@ func (curl *Request) HttpRequest() (req *http.Request, err error) {
 	return req, err
 }
 
+func (curl *Request) TimeOut() time.Duration {
+	if curl.Timeout > 0 {
+		return curl.Timeout
+	}
+	return curl.BaseRequest.TimeOut()
+}
+
+func (curl *Request) AttemptTimeOut() time.Duration {
+	if curl.AttemptTimeout > 0 {
+		return curl.AttemptTimeout
+	}
+	return curl.BaseRequest.AttemptTimeOut()
+}
+
 func (curl *Request) String() string {
 	return fmt.Sprintf("\n %s Url:%s, \n Method:%s,\n Header:%#v,\n Params:%#v,\n Data:%#v,\n Body:%v \n",
 		curl.BaseRequest.String(),
@@ -169,6 +184,13 @@ type HttpConfig struct {
 	Headers map[string]string
 	// 如果Body不为nil，则会覆盖Data数据，也就是说Body优先级高于Data
 	Body []byte
+
+	// 整个请求（包括所有尝试以及重试等待）的超时时间
+	// 为零时不限制
+	Timeout time.Duration
+	// 每一次尝试的超时时间
+	// 为零时采用客户端默认的超时时间
+	AttemptTimeout time.Duration
 }
 
 // http 请求
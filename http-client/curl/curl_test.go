@@ -2,7 +2,12 @@ package curl
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
 )
 
 func TestCurl(t *testing.T) {
@@ -24,3 +29,24 @@ func TestCurl(t *testing.T) {
 	}
 	fmt.Println(respmap)
 }
+
+func TestHttpCurlTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	_, err := HttpCurl(HttpConfig{
+		Method:  GET,
+		Url:     ts.URL,
+		Timeout: 50 * time.Millisecond,
+	})
+	if !core.IsTimeout(err) {
+		t.Fatal("Timeout", err)
+	}
+
+	req := &Request{HttpConfig: HttpConfig{AttemptTimeout: time.Second}}
+	if req.TimeOut() != -1 || req.AttemptTimeOut() != time.Second {
+		t.Fatal("TimeOut", req.TimeOut(), req.AttemptTimeOut())
+	}
+}
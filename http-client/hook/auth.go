@@ -0,0 +1,375 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+var ErrEmptyToken = errors.New("empty bearer token")
+
+// 令牌获取函数
+// @return expiry 过期时间，为零时永不过期
+type TokenSource func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// 固定的令牌
+func StaticTokenSource(token string) TokenSource {
+	return func(ctx context.Context) (string, time.Time, error) {
+		return token, time.Time{}, nil
+	}
+}
+
+const (
+	// 默认提前刷新令牌的时间
+	defaultTokenExpirySkew = 10 * time.Second
+	// 令牌在过期之前这段时间内视为已经过期，避免发送之后才过期
+	tokenExpiryDelta = time.Second
+)
+
+// Bearer令牌钩子
+//   每一次发送请求之前添加 Authorization: Bearer <token> 头部。
+//   令牌缓存到过期为止，过期之前skew时间内在后台提前刷新，
+//   并发请求同时需要刷新令牌时只会获取一次。
+//   收到401响应时作废当前令牌，下一次请求将重新获取。
+type BearerTokenHook struct {
+	source TokenSource
+	skew   time.Duration
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+	// 正在进行的令牌获取
+	call *tokenCall
+}
+
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+func (bh *BearerTokenHook) BeforeRequest(req core.Request, client core.Client) error {
+	return nil
+}
+
+func (bh *BearerTokenHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+}
+
+func (bh *BearerTokenHook) BeforeSend(httpReq *http.Request, req core.Request) error {
+	token, err := bh.Token(httpReq.Context())
+	if nil != err {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (bh *BearerTokenHook) AfterReceive(httpResp *http.Response, req core.Request) error {
+	if httpResp.StatusCode == http.StatusUnauthorized && nil != httpResp.Request {
+		bh.invalidate(strings.TrimPrefix(httpResp.Request.Header.Get("Authorization"), "Bearer "))
+	}
+	return nil
+}
+
+// 获取令牌
+//   没有令牌或者已经过期时等待获取；即将过期时返回当前令牌，并在后台刷新
+func (bh *BearerTokenHook) Token(ctx context.Context) (string, error) {
+	bh.mutex.Lock()
+	now := time.Now()
+	if bh.valid(now) {
+		token := bh.token
+		if bh.stale(now) {
+			bh.refresh()
+		}
+		bh.mutex.Unlock()
+		return token, nil
+	}
+	call := bh.refresh()
+	bh.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// 开始获取令牌，已经在获取时直接返回，调用时需持有锁
+//   获取令牌不受单个请求的上下文影响，以免其取消时其它等待的请求也失败
+func (bh *BearerTokenHook) refresh() *tokenCall {
+	if nil != bh.call {
+		return bh.call
+	}
+	call := &tokenCall{done: make(chan struct{})}
+	bh.call = call
+	go func() {
+		token, expiry, err := bh.source(context.Background())
+		if nil == err && token == "" {
+			err = ErrEmptyToken
+		}
+		bh.mutex.Lock()
+		if nil == err {
+			bh.token, bh.expiry = token, expiry
+		}
+		bh.call = nil
+		bh.mutex.Unlock()
+		call.token, call.err = token, err
+		close(call.done)
+	}()
+	return call
+}
+
+// 作废当前令牌
+func (bh *BearerTokenHook) Invalidate() {
+	bh.mutex.Lock()
+	defer bh.mutex.Unlock()
+	bh.token = ""
+}
+
+// 作废指定的令牌，令牌已经刷新时忽略
+func (bh *BearerTokenHook) invalidate(token string) {
+	bh.mutex.Lock()
+	defer bh.mutex.Unlock()
+	if token == bh.token {
+		bh.token = ""
+	}
+}
+
+// 令牌是否可用，调用时需持有锁
+func (bh *BearerTokenHook) valid(now time.Time) bool {
+	return bh.token != "" && (bh.expiry.IsZero() || now.Add(tokenExpiryDelta).Before(bh.expiry))
+}
+
+// 令牌是否需要提前刷新，调用时需持有锁
+func (bh *BearerTokenHook) stale(now time.Time) bool {
+	return !bh.expiry.IsZero() && !now.Add(bh.skew).Before(bh.expiry)
+}
+
+// 新建Bearer令牌钩子
+// @params source 令牌获取函数
+// @params skew   提前刷新令牌的时间，为零时默认为10秒
+func NewBearerTokenHook(source TokenSource, skew time.Duration) *BearerTokenHook {
+	if skew <= 0 {
+		skew = defaultTokenExpirySkew
+	}
+	return &BearerTokenHook{source: source, skew: skew}
+}
+
+const (
+	// 签名时间头部
+	HMACDateHeader = "X-Date"
+	// 请求body的SHA256（十六进制）头部
+	HMACContentHashHeader = "X-Content-SHA256"
+
+	hmacDateFormat       = "20060102T150405Z"
+	defaultHMACAlgorithm = "HMAC-SHA256"
+)
+
+// KeyID 密钥ID
+//
+// Secret 密钥
+//
+// Hash 签名哈希算法。如果为nil，默认为sha256.New
+//
+// Algorithm 签名算法名称，写入签名头部。如果为空，默认为 HMAC-SHA256
+//
+// SignedHeaders 参与签名的其它头部（Host、X-Date、X-Content-SHA256总是参与签名）
+//
+// Header 签名头部。如果为空，默认为 Authorization
+type HMACSettings struct {
+	KeyID         string
+	Secret        []byte
+	Hash          func() hash.Hash
+	Algorithm     string
+	SignedHeaders []string
+	Header        string
+}
+
+// HMAC签名钩子
+//   每一次发送请求之前对请求签名，添加 X-Date、X-Content-SHA256 以及签名头部：
+//     Authorization: HMAC-SHA256 Credential=<KeyID>, SignedHeaders=host;x-content-sha256;x-date, Signature=<hex>
+//   签名内容（以换行符连接）：
+//     请求方法、路径、排序之后的查询参数、参与签名的头部（小写名称:值，按名称排序）、
+//     参与签名的头部名称（以分号连接）、请求body的SHA256
+//   签名之后不能再修改请求，添加钩子时应放在其它修改请求的钩子之后
+type HMACSignHook struct {
+	settings HMACSettings
+	// 参与签名的头部（小写，排序）
+	signedHeaders []string
+}
+
+func (hh *HMACSignHook) BeforeRequest(req core.Request, client core.Client) error {
+	return nil
+}
+
+func (hh *HMACSignHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+}
+
+func (hh *HMACSignHook) BeforeSend(httpReq *http.Request, req core.Request) error {
+	return hh.Sign(httpReq, time.Now())
+}
+
+func (hh *HMACSignHook) AfterReceive(httpResp *http.Response, req core.Request) error {
+	return nil
+}
+
+// 对请求签名
+func (hh *HMACSignHook) Sign(httpReq *http.Request, now time.Time) error {
+	bodyHash, err := hashBody(httpReq)
+	if nil != err {
+		return err
+	}
+	httpReq.Header.Set(HMACDateHeader, now.UTC().Format(hmacDateFormat))
+	httpReq.Header.Set(HMACContentHashHeader, bodyHash)
+	signature := hh.signature(httpReq, hh.signedHeaders, bodyHash)
+	httpReq.Header.Set(hh.settings.Header, hh.settings.Algorithm+
+		" Credential="+hh.settings.KeyID+
+		", SignedHeaders="+strings.Join(hh.signedHeaders, ";")+
+		", Signature="+signature)
+	return nil
+}
+
+// 验证请求的签名（服务端使用）
+// @params maxSkew 签名时间与当前时间的最大误差，为零时不检查
+func (hh *HMACSignHook) Verify(httpReq *http.Request, maxSkew time.Duration) bool {
+	auth := httpReq.Header.Get(hh.settings.Header)
+	if !strings.HasPrefix(auth, hh.settings.Algorithm+" ") {
+		return false
+	}
+	params := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, hh.settings.Algorithm+" "), ",") {
+		if kv := strings.SplitN(strings.TrimSpace(part), "=", 2); len(kv) == 2 {
+			params[kv[0]] = kv[1]
+		}
+	}
+	if params["Credential"] != hh.settings.KeyID || params["Signature"] == "" {
+		return false
+	}
+	if maxSkew > 0 {
+		t, err := time.Parse(hmacDateFormat, httpReq.Header.Get(HMACDateHeader))
+		if nil != err || t.Sub(time.Now()) > maxSkew || time.Now().Sub(t) > maxSkew {
+			return false
+		}
+	}
+	// 要求的头部都需要参与签名
+	signedHeaders := strings.Split(params["SignedHeaders"], ";")
+	signed := make(map[string]bool, len(signedHeaders))
+	for _, name := range signedHeaders {
+		signed[name] = true
+	}
+	for _, name := range hh.signedHeaders {
+		if !signed[name] {
+			return false
+		}
+	}
+	bodyHash, err := hashBody(httpReq)
+	if nil != err || bodyHash != httpReq.Header.Get(HMACContentHashHeader) {
+		return false
+	}
+	signature := hh.signature(httpReq, signedHeaders, bodyHash)
+	return hmac.Equal([]byte(signature), []byte(params["Signature"]))
+}
+
+func (hh *HMACSignHook) signature(httpReq *http.Request, signedHeaders []string, bodyHash string) string {
+	mac := hmac.New(hh.settings.Hash, hh.settings.Secret)
+	mac.Write([]byte(canonicalRequest(httpReq, signedHeaders, bodyHash)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 新建HMAC签名钩子
+func NewHMACSignHook(settings HMACSettings) *HMACSignHook {
+	if nil == settings.Hash {
+		settings.Hash = sha256.New
+	}
+	if settings.Algorithm == "" {
+		settings.Algorithm = defaultHMACAlgorithm
+	}
+	if settings.Header == "" {
+		settings.Header = "Authorization"
+	}
+	names := map[string]bool{"host": true, strings.ToLower(HMACDateHeader): true, strings.ToLower(HMACContentHashHeader): true}
+	for _, name := range settings.SignedHeaders {
+		names[strings.ToLower(name)] = true
+	}
+	signedHeaders := make([]string, 0, len(names))
+	for name := range names {
+		signedHeaders = append(signedHeaders, name)
+	}
+	sort.Strings(signedHeaders)
+	return &HMACSignHook{settings: settings, signedHeaders: signedHeaders}
+}
+
+// 签名内容
+func canonicalRequest(httpReq *http.Request, signedHeaders []string, bodyHash string) string {
+	method := httpReq.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := httpReq.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	var b bytes.Buffer
+	b.WriteString(method + "\n")
+	b.WriteString(path + "\n")
+	// Encode()按参数名排序
+	b.WriteString(httpReq.URL.Query().Encode() + "\n")
+	for _, name := range signedHeaders {
+		val := ""
+		if name == "host" {
+			val = httpReq.Host
+			if val == "" {
+				val = httpReq.URL.Host
+			}
+		} else {
+			val = strings.Join(httpReq.Header[http.CanonicalHeaderKey(name)], ",")
+		}
+		b.WriteString(name + ":" + strings.TrimSpace(val) + "\n")
+	}
+	b.WriteString(strings.Join(signedHeaders, ";") + "\n")
+	b.WriteString(bodyHash)
+	return b.String()
+}
+
+// 计算请求body的SHA256，读取之后body可以再次读取
+func hashBody(httpReq *http.Request) (string, error) {
+	h := sha256.New()
+	if nil != httpReq.Body && httpReq.Body != http.NoBody {
+		var body []byte
+		var err error
+		if nil != httpReq.GetBody {
+			rc, e := httpReq.GetBody()
+			if nil != e {
+				return "", e
+			}
+			body, err = ioutil.ReadAll(rc)
+			rc.Close()
+		} else {
+			body, err = ioutil.ReadAll(httpReq.Body)
+			httpReq.Body.Close()
+			httpReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+			httpReq.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+		if nil != err {
+			return "", err
+		}
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,164 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+type TestPostRequest struct {
+	TestRequest
+	Body string
+}
+
+func (t *TestPostRequest) HttpRequest() (*http.Request, error) {
+	return http.NewRequest("POST", t.RequestURL+"?b=2&a=1", strings.NewReader(t.Body))
+}
+
+func TestBearerTokenHook(t *testing.T) {
+	var valid atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+valid.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	var fetches int32
+	source := func(ctx context.Context) (string, time.Time, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		return "token" + strconv.Itoa(int(n)), time.Now().Add(time.Hour), nil
+	}
+	valid.Store("token1")
+	bearer := NewBearerTokenHook(source, time.Minute)
+	client := core.NewClient("test", nil).AppendHook(bearer).SetMaxBadRetryCount(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.DoRequest(&TestRequest{RequestURL: ts.URL})
+			if nil != err || resp.StatusCode != http.StatusOK {
+				t.Error("DoRequest", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&fetches) != 1 {
+		t.Fatal("token should be fetched once", fetches)
+	}
+
+	// 令牌失效，401之后重新获取
+	valid.Store("token2")
+	if resp, err := client.DoRequest(&TestRequest{RequestURL: ts.URL}); nil != err || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("401", err)
+	}
+	if resp, err := client.DoRequest(&TestRequest{RequestURL: ts.URL}); nil != err || resp.StatusCode != http.StatusOK {
+		t.Fatal("token should be refreshed", err)
+	}
+
+	// 即将过期的令牌提前刷新
+	expiring := NewBearerTokenHook(func(ctx context.Context) (string, time.Time, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		return "token" + strconv.Itoa(int(n)), time.Now().Add(5 * time.Second), nil
+	}, 10*time.Second)
+	t1, _ := expiring.Token(context.Background())
+	deadline := time.Now().Add(time.Second)
+	for {
+		// 即将过期的令牌仍然可用，同时在后台刷新
+		t2, err := expiring.Token(context.Background())
+		if nil != err {
+			t.Fatal("Token", err)
+		}
+		if t2 != t1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expiring token should be refreshed", t1, t2)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	failing := NewBearerTokenHook(func(ctx context.Context) (string, time.Time, error) {
+		return "", time.Time{}, errors.New("token server down")
+	}, 0)
+	client = core.NewClient("test", nil).AppendHook(failing)
+	if _, err := client.DoRequest(&TestRequest{RequestURL: ts.URL}); nil == err || !strings.Contains(err.Error(), "token server down") {
+		t.Fatal("token error", err)
+	}
+}
+
+func TestHMACSignHook(t *testing.T) {
+	signer := NewHMACSignHook(HMACSettings{KeyID: "app", Secret: []byte("secret"), SignedHeaders: []string{"Content-Type"}})
+	verifier := NewHMACSignHook(HMACSettings{KeyID: "app", Secret: []byte("secret"), SignedHeaders: []string{"content-type"}})
+	var verified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !verifier.Verify(r, time.Minute) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		atomic.AddInt32(&verified, 1)
+		if atomic.LoadInt32(&verified) == 1 {
+			// 重试时重新签名
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	headers := NewHeaderHook(http.Header{"content-type": {"application/json"}}, false)
+	client := core.NewClient("test", nil).AppendHook(headers, signer)
+	client.SetRetryPolicy(&core.BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond, RetryNonIdempotent: true})
+	req := &TestPostRequest{TestRequest: TestRequest{RequestURL: ts.URL + "/orders"}, Body: `{"id":1}`}
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.StatusCode != http.StatusOK || atomic.LoadInt32(&verified) != 2 {
+		t.Fatal("DoRequest", err, resp.StatusCode)
+	}
+	raw := req.RawRequest()
+	if raw.Header.Get(HMACContentHashHeader) == "" || !strings.HasPrefix(raw.Header.Get("Authorization"), "HMAC-SHA256 Credential=app, SignedHeaders=content-type;host;x-content-sha256;x-date, Signature=") {
+		t.Fatal("Authorization", raw.Header)
+	}
+
+	// 密钥错误
+	wrong := NewHMACSignHook(HMACSettings{KeyID: "app", Secret: []byte("wrong")})
+	client = core.NewClient("test", nil).AppendHook(wrong).SetMaxBadRetryCount(1)
+	if resp, err := client.DoRequest(req); nil != err || resp.StatusCode != http.StatusForbidden {
+		t.Fatal("wrong secret should be rejected", err)
+	}
+
+	// 签名之后修改body
+	httpReq, _ := req.HttpRequest()
+	signer.Sign(httpReq, time.Now())
+	httpReq.Body = http.NoBody
+	httpReq.GetBody = nil
+	if signer.Verify(httpReq, 0) {
+		t.Fatal("modified body should not be verified")
+	}
+}
+
+func TestHeaderHook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-App-Id") + "," + r.Header.Get("Accept")))
+	}))
+	defer ts.Close()
+
+	req := &TestHeaderRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Header: http.Header{"Accept": {"text/plain"}}}
+	header := http.Header{"x-app-id": {"demo"}, "Accept": {"application/json"}}
+	client := core.NewClient("test", nil).AppendHook(NewHeaderHook(header, false))
+	if resp, err := client.DoRequest(req); nil != err || resp.ToString() != "demo,text/plain" {
+		t.Fatal("HeaderHook", err, resp.ToString())
+	}
+	client = core.NewClient("test", nil).AppendHook(NewHeaderHook(header, true))
+	if resp, err := client.DoRequest(req); nil != err || resp.ToString() != "demo,application/json" {
+		t.Fatal("HeaderHook override", err, resp.ToString())
+	}
+}
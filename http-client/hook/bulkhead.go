@@ -0,0 +1,232 @@
+package hook
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+var (
+	ErrBulkheadFull    = errors.New("bulkhead is full")
+	ErrBulkheadTimeout = errors.New("bulkhead wait timeout")
+)
+
+const (
+	BulkheadHookKey = "BulkheadHook"
+
+	// 默认最大并发请求数
+	defaultBulkheadMaxConcurrent = 10
+)
+
+// MaxConcurrent 每个服务的最大并发请求数。如果小于等于零，默认为10
+//
+// MaxWait 等待队列长度。为零时并发数已满的请求直接被拒绝
+//
+// WaitTimeout 排队等待的最长时间。为零时一直等待，直到请求的上下文结束
+type BulkheadSettings struct {
+	MaxConcurrent int
+	MaxWait       int
+	WaitTimeout   time.Duration
+}
+
+// 舱壁状态
+type BulkheadStats struct {
+	// 正在处理的请求数
+	Active int
+	// 排队等待的请求数
+	Waiting int
+	// 被拒绝的请求数（包括等待超时）
+	Rejected uint64
+}
+
+// 舱壁钩子
+//   按 ServerName() 限制并发请求数，以免单个缓慢的服务耗尽goroutine以及连接。
+//   并发数已满时请求进入等待队列（先进先出），队列已满返回ErrBulkheadFull，
+//   等待超时返回ErrBulkheadTimeout，请求上下文结束时返回其错误。
+//   名额在AfterRequest中释放，即请求结束之后（包括重试）。
+type BulkheadHook struct {
+	settings BulkheadSettings
+
+	mutex     sync.Mutex
+	limits    map[string]int
+	bulkheads map[string]*bulkhead
+}
+
+func (bh *BulkheadHook) BeforeRequest(req core.Request, client core.Client) error {
+	// 清理上一次请求的数据
+	req.SetHookData(BulkheadHookKey, nil)
+	b := bh.getBulkhead(req.ServerName())
+	if err := b.acquire(req.Context(), bh.settings.MaxWait, bh.settings.WaitTimeout); nil != err {
+		return err
+	}
+	req.SetHookData(BulkheadHookKey, &bulkheadPermit{b: b})
+	return nil
+}
+
+func (bh *BulkheadHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+	data, ok := req.HookData(BulkheadHookKey)
+	if !ok {
+		return
+	}
+	permit, ok := data.(*bulkheadPermit)
+	if !ok {
+		return
+	}
+	// 每次请求只释放一次
+	req.SetHookData(BulkheadHookKey, nil)
+	permit.release()
+}
+
+// 设置某个服务的最大并发请求数，覆盖默认设置
+func (bh *BulkheadHook) SetLimit(serverName string, maxConcurrent int) *BulkheadHook {
+	if maxConcurrent <= 0 {
+		maxConcurrent = bh.settings.MaxConcurrent
+	}
+	bh.mutex.Lock()
+	bh.limits[serverName] = maxConcurrent
+	b, ok := bh.bulkheads[serverName]
+	bh.mutex.Unlock()
+	if ok {
+		b.setMax(maxConcurrent)
+	}
+	return bh
+}
+
+// 服务的舱壁状态
+func (bh *BulkheadHook) Stats(serverName string) BulkheadStats {
+	bh.mutex.Lock()
+	b, ok := bh.bulkheads[serverName]
+	bh.mutex.Unlock()
+	if !ok {
+		return BulkheadStats{}
+	}
+	return b.stats()
+}
+
+func (bh *BulkheadHook) getBulkhead(serverName string) *bulkhead {
+	bh.mutex.Lock()
+	defer bh.mutex.Unlock()
+	b, ok := bh.bulkheads[serverName]
+	if !ok {
+		max, ok := bh.limits[serverName]
+		if !ok {
+			max = bh.settings.MaxConcurrent
+		}
+		b = &bulkhead{max: max, waiters: list.New()}
+		bh.bulkheads[serverName] = b
+	}
+	return b
+}
+
+func NewBulkheadHook(settings BulkheadSettings) *BulkheadHook {
+	if settings.MaxConcurrent <= 0 {
+		settings.MaxConcurrent = defaultBulkheadMaxConcurrent
+	}
+	if settings.MaxWait < 0 {
+		settings.MaxWait = 0
+	}
+	return &BulkheadHook{
+		settings:  settings,
+		limits:    make(map[string]int),
+		bulkheads: make(map[string]*bulkhead),
+	}
+}
+
+// 单个服务的舱壁
+//   释放名额时直接交给队首的等待者，保证先进先出
+type bulkhead struct {
+	mutex    sync.Mutex
+	max      int
+	active   int
+	rejected uint64
+	// 等待者，元素为chan struct{}，获得名额时被关闭
+	waiters *list.List
+}
+
+func (b *bulkhead) acquire(ctx core.Context, maxWait int, timeout time.Duration) error {
+	b.mutex.Lock()
+	if b.active < b.max && b.waiters.Len() == 0 {
+		b.active++
+		b.mutex.Unlock()
+		return nil
+	}
+	if b.waiters.Len() >= maxWait {
+		b.rejected++
+		b.mutex.Unlock()
+		return ErrBulkheadFull
+	}
+	ready := make(chan struct{})
+	elem := b.waiters.PushBack(ready)
+	b.mutex.Unlock()
+
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timeoutC:
+		err = ErrBulkheadTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	select {
+	case <-ready:
+		// 超时的同时获得了名额，直接使用
+		return nil
+	default:
+	}
+	b.waiters.Remove(elem)
+	b.rejected++
+	return err
+}
+
+func (b *bulkhead) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.active--
+	b.wakeup()
+}
+
+// 请求占用的名额，多次释放只释放一次
+type bulkheadPermit struct {
+	b    *bulkhead
+	once sync.Once
+}
+
+func (p *bulkheadPermit) release() {
+	p.once.Do(p.b.release)
+}
+
+func (b *bulkhead) setMax(max int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.max = max
+	b.wakeup()
+}
+
+// 将空闲名额交给等待者，调用时需持有锁
+func (b *bulkhead) wakeup() {
+	for b.active < b.max && b.waiters.Len() > 0 {
+		elem := b.waiters.Front()
+		b.waiters.Remove(elem)
+		close(elem.Value.(chan struct{}))
+		b.active++
+	}
+}
+
+func (b *bulkhead) stats() BulkheadStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return BulkheadStats{Active: b.active, Waiting: b.waiters.Len(), Rejected: b.rejected}
+}
@@ -0,0 +1,162 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+var errRejected = errors.New("rejected")
+
+type rejectHook struct{}
+
+func (rejectHook) BeforeRequest(req core.Request, client core.Client) error {
+	return errRejected
+}
+
+func (rejectHook) AfterRequest(cErr error, req core.Request, client core.Client) {}
+
+// 阻塞直到block被关闭的服务
+func newBlockingServer(started chan<- struct{}, block <-chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-block
+	}))
+}
+
+func waitBulkhead(t *testing.T, bh *BulkheadHook, name string, cond func(BulkheadStats) bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond(bh.Stats(name)) {
+		if time.Now().After(deadline) {
+			t.Fatal("wait bulkhead", bh.Stats(name))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBulkheadHook_Limit(t *testing.T) {
+	started, block := make(chan struct{}, 4), make(chan struct{})
+	ts := newBlockingServer(started, block)
+	defer ts.Close()
+
+	bh := NewBulkheadHook(BulkheadSettings{MaxConcurrent: 2, MaxWait: 1})
+	client := core.NewClient("test", nil).AppendHook(bh).SetMaxBadRetryCount(1)
+	do := func(errs chan<- error) {
+		req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "slow"}
+		_, err := client.DoRequest(req)
+		errs <- err
+	}
+
+	errs := make(chan error, 3)
+	go do(errs)
+	go do(errs)
+	<-started
+	<-started
+	go do(errs)
+	waitBulkhead(t, bh, "slow", func(s BulkheadStats) bool { return s.Waiting == 1 })
+
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "slow"}
+	if _, err := client.DoRequest(req); err != ErrBulkheadFull {
+		t.Fatal("queue should be full", err)
+	}
+	if stats := bh.Stats("slow"); stats.Active != 2 || stats.Waiting != 1 || stats.Rejected != 1 {
+		t.Fatal("Stats", stats)
+	}
+
+	close(block)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; nil != err {
+			t.Fatal("DoRequest", err)
+		}
+	}
+	if stats := bh.Stats("slow"); stats.Active != 0 || stats.Waiting != 0 {
+		t.Fatal("slot should be released", stats)
+	}
+}
+
+func TestBulkheadHook_WaitTimeout(t *testing.T) {
+	started, block := make(chan struct{}, 1), make(chan struct{})
+	ts := newBlockingServer(started, block)
+	defer ts.Close()
+	defer close(block)
+
+	bh := NewBulkheadHook(BulkheadSettings{MaxConcurrent: 1, MaxWait: 1, WaitTimeout: 20 * time.Millisecond})
+	client := core.NewClient("test", nil).AppendHook(bh).SetMaxBadRetryCount(1)
+	go client.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "slow"})
+	<-started
+
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "slow"}
+	if _, err := client.DoRequest(req); err != ErrBulkheadTimeout {
+		t.Fatal("wait timeout", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := client.DoRequestContext(ctx, req); err != context.DeadlineExceeded {
+		t.Fatal("context timeout", err)
+	}
+	if stats := bh.Stats("slow"); stats.Active != 1 || stats.Waiting != 0 || stats.Rejected != 2 {
+		t.Fatal("Stats", stats)
+	}
+
+	// 其它服务不受影响
+	other := &TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "other"}
+	client.DoRequest(other)
+	if stats := bh.Stats("other"); stats.Active != 0 || stats.Rejected != 0 {
+		t.Fatal("other Stats", stats)
+	}
+}
+
+func TestBulkheadHook_SetLimit(t *testing.T) {
+	bh := NewBulkheadHook(BulkheadSettings{MaxConcurrent: 1, MaxWait: 1})
+	b := bh.getBulkhead("test")
+	if err := b.acquire(context.Background(), 1, 0); nil != err {
+		t.Fatal("acquire", err)
+	}
+	acquired := make(chan error, 1)
+	go func() { acquired <- b.acquire(context.Background(), 1, 0) }()
+	waitBulkhead(t, bh, "test", func(s BulkheadStats) bool { return s.Waiting == 1 })
+
+	bh.SetLimit("test", 2)
+	if err := <-acquired; nil != err {
+		t.Fatal("waiter should acquire after SetLimit", err)
+	}
+	if stats := bh.Stats("test"); stats.Active != 2 {
+		t.Fatal("Stats", stats)
+	}
+}
+
+func TestBulkheadHook_ReleaseOnReject(t *testing.T) {
+	bh := NewBulkheadHook(BulkheadSettings{MaxConcurrent: 1})
+	circuitHook := NewCircuitHook(CircuitSettings{})
+	client := core.NewClient("test", nil).AppendHook(circuitHook, bh, rejectHook{})
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "test"}
+	for i := 0; i < 3; i++ {
+		if _, err := client.DoRequest(req); err != errRejected {
+			t.Fatal("DoRequest", err)
+		}
+	}
+	if stats := bh.Stats("test"); stats.Active != 0 || stats.Rejected != 0 {
+		t.Fatal("slot should be released", stats)
+	}
+	// 没有发送的请求不计入断路器
+	cb, _ := circuitHook.Breaker("test")
+	if counts := cb.Counts(); counts.Requests != 0 || counts.TotalFailures != 0 {
+		t.Fatal("Counts", counts)
+	}
+
+	// 之前的钩子拒绝请求时，没有占用名额也不会释放
+	client = core.NewClient("test", nil).AppendHook(rejectHook{}, bh)
+	for i := 0; i < 3; i++ {
+		if _, err := client.DoRequest(req); err != errRejected {
+			t.Fatal("DoRequest", err)
+		}
+	}
+	if stats := bh.Stats("test"); stats.Active != 0 {
+		t.Fatal("release should be idempotent", stats)
+	}
+}
@@ -0,0 +1,418 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+const (
+	CacheHookKey = "CacheHook"
+
+	// 缓存状态，见 CacheStatus()
+	CacheMiss        = "MISS"
+	CacheHit         = "HIT"
+	CacheRevalidated = "REVALIDATED"
+	CacheBypass      = "BYPASS"
+
+	// 过期之后保留缓存以便重新验证的默认时间
+	defaultCacheStaleTTL = time.Hour
+)
+
+// 缓存的响应状态码（没有明确的过期时间时也可以通过ETag或者Last-Modified重新验证）
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// Storage 缓存存储，如 NewMemoryCacheStorage()、NewRedisCacheStorage()
+//
+// StaleTTL 响应过期之后，带有ETag或者Last-Modified的缓存继续保留的时间，
+//          以便通过条件请求重新验证。如果为零，默认为1小时
+//
+// MaxBodySize 缓存的响应body最大字节数，超过的响应不缓存。为零时不限制
+type CacheSettings struct {
+	Storage     CacheStorage
+	StaleTTL    time.Duration
+	MaxBodySize int
+}
+
+// 缓存钩子
+//   缓存GET请求的响应，以请求方法、URL以及Vary指定的请求头部为键。
+//   遵循响应的 Cache-Control（max-age、no-cache、no-store）以及 Expires 头部，
+//   缓存过期之后通过 If-None-Match、If-Modified-Since 条件请求重新验证，
+//   服务端返回304时采用缓存的响应。
+//   请求头部带有 Cache-Control: no-store 时不使用缓存，no-cache 或者 max-age=0 时强制重新验证。
+//   缓存可能由多个进程、多个用户共享（如Redis），所以按共享缓存处理（RFC 9111 3.5）：
+//   Cache-Control: private 的响应不缓存；发送的请求带有Authorization头部
+//   （包括BearerTokenHook等在BeforeSend中添加的）时，只缓存 public 或者 s-maxage 的响应。
+//   缓存命中时不会发送请求（ReqCount()为0），可以通过CacheStatus()获取缓存状态；
+//   重新验证（服务端返回304）的响应来源为网络（SourceNetwork）。
+type CacheHook struct {
+	settings CacheSettings
+}
+
+func (ch *CacheHook) BeforeRequest(req core.Request, client core.Client) error {
+	req.SetHookData(CacheHookKey, nil)
+	return nil
+}
+
+func (ch *CacheHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+}
+
+func (ch *CacheHook) RoundTrip(req core.Request, client core.Client, next core.RoundTripFunc) (*core.Response, error) {
+	httpReq, err := req.HttpRequest()
+	if nil != err {
+		return nil, err
+	}
+	reqCC := parseCacheControl(httpReq.Header)
+	if !isCacheableRequest(httpReq) || reqCC.has("no-store") {
+		req.SetHookData(CacheHookKey, CacheBypass)
+		return next(req)
+	}
+
+	now := time.Now()
+	key := cacheKey(httpReq)
+	entry, entryKey := ch.lookup(key, httpReq)
+	if nil != entry && !reqCC.has("no-cache") && reqCC["max-age"] != "0" && entry.fresh(now) {
+		req.SetHookData(CacheHookKey, CacheHit)
+		return entry.response(now), nil
+	}
+
+	var resp *core.Response
+	if nil != entry && entry.hasValidator() {
+		resp, err = next(&conditionalRequest{Request: req, entry: entry})
+	} else {
+		resp, err = next(req)
+	}
+	if nil != err || nil == resp || nil == resp.Response {
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && nil != entry {
+		resp.Body.Close()
+		entry.revalidate(resp.Response, time.Now())
+		ch.store(key, entryKey, entry)
+		req.SetHookData(CacheHookKey, CacheRevalidated)
+		// 已经发送请求，以缓存的内容替换304响应，来源仍为网络
+		revalidated := entry.response(time.Now()).Response
+		revalidated.Request = resp.Request
+		resp.Response = revalidated
+		return resp, nil
+	}
+	req.SetHookData(CacheHookKey, CacheMiss)
+	if newEntry, ok := ch.newEntry(httpReq, resp, time.Now()); ok {
+		ch.store(key, cacheVariantKey(key, newEntry.Vary, httpReq.Header), newEntry)
+	} else if nil != entry && resp.StatusCode < http.StatusInternalServerError {
+		// 资源已经不可缓存
+		ch.settings.Storage.Delete(entryKey)
+	}
+	return resp, nil
+}
+
+// 查找缓存
+// @return entryKey 缓存的实际键（有Vary时为变体的键）
+func (ch *CacheHook) lookup(key string, httpReq *http.Request) (entry *cacheEntry, entryKey string) {
+	entry = ch.get(key)
+	if nil == entry || len(entry.Vary) == 0 {
+		return entry, key
+	}
+	entryKey = cacheVariantKey(key, entry.Vary, httpReq.Header)
+	return ch.get(entryKey), entryKey
+}
+
+func (ch *CacheHook) get(key string) *cacheEntry {
+	data, ok := ch.settings.Storage.Get(key)
+	if !ok {
+		return nil
+	}
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(data, entry); nil != err {
+		return nil
+	}
+	return entry
+}
+
+// 保存缓存
+// 有Vary时，在主键中保存Vary头部名称，在变体的键中保存响应
+func (ch *CacheHook) store(key, entryKey string, entry *cacheEntry) {
+	ttl := entry.ttl(time.Now(), ch.settings.StaleTTL)
+	if ttl <= 0 {
+		return
+	}
+	if len(entry.Vary) > 0 {
+		index, _ := json.Marshal(&cacheEntry{Vary: entry.Vary})
+		ch.settings.Storage.Set(key, index, ttl)
+	}
+	if data, err := json.Marshal(entry); nil == err {
+		ch.settings.Storage.Set(entryKey, data, ttl)
+	}
+}
+
+// 根据响应新建缓存，不可缓存时返回false
+func (ch *CacheHook) newEntry(httpReq *http.Request, resp *core.Response, now time.Time) (*cacheEntry, bool) {
+	if !cacheableStatus[resp.StatusCode] || resp.IsStream() {
+		return nil, false
+	}
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") || resp.Header.Get("Vary") == "*" {
+		return nil, false
+	}
+	// 带有凭证的请求，响应需要明确允许共享缓存
+	if (hasAuthorization(httpReq) || hasAuthorization(resp.Request)) && !cc.has("public") && !cc.has("s-maxage") {
+		return nil, false
+	}
+	entry := &cacheEntry{
+		StatusCode:   resp.StatusCode,
+		Header:       cloneHeader(resp.Header),
+		ResponseTime: now,
+		Vary:         parseVary(resp.Header),
+	}
+	entry.setFreshness(now)
+	if entry.Lifetime <= 0 && !entry.hasValidator() {
+		return nil, false
+	}
+	if ch.settings.MaxBodySize > 0 && resp.ContentLength > int64(ch.settings.MaxBodySize) {
+		return nil, false
+	}
+	body, err := resp.Bytes()
+	if nil != err || (ch.settings.MaxBodySize > 0 && len(body) > ch.settings.MaxBodySize) {
+		return nil, false
+	}
+	// Bytes()已经解压
+	entry.Header.Del("Content-Encoding")
+	entry.Header.Del("Content-Length")
+	entry.Body = body
+	return entry, true
+}
+
+func NewCacheHook(settings CacheSettings) *CacheHook {
+	if nil == settings.Storage {
+		settings.Storage = NewMemoryCacheStorage(0)
+	}
+	if settings.StaleTTL <= 0 {
+		settings.StaleTTL = defaultCacheStaleTTL
+	}
+	return &CacheHook{settings: settings}
+}
+
+// 获取请求的缓存状态：CacheHit、CacheRevalidated、CacheMiss、CacheBypass。
+// 没有经过缓存钩子时返回空字符串
+func CacheStatus(req core.Request) string {
+	data, ok := req.HookData(CacheHookKey)
+	if !ok {
+		return ""
+	}
+	status, _ := data.(string)
+	return status
+}
+
+// 缓存的响应
+type cacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// 收到响应的时间
+	ResponseTime time.Time
+	// 收到响应时的Age（包括Date与ResponseTime之差）
+	InitialAge time.Duration
+	// 有效时长
+	Lifetime time.Duration
+	// Vary指定的请求头部名称
+	Vary []string
+}
+
+// 计算有效时长以及Age
+func (e *cacheEntry) setFreshness(now time.Time) {
+	e.ResponseTime = now
+	e.InitialAge = 0
+	date, dateErr := http.ParseTime(e.Header.Get("Date"))
+	if nil == dateErr && now.After(date) {
+		e.InitialAge = now.Sub(date)
+	}
+	if age, err := strconv.Atoi(e.Header.Get("Age")); nil == err && age > 0 {
+		e.InitialAge += time.Duration(age) * time.Second
+	}
+
+	cc := parseCacheControl(e.Header)
+	e.Lifetime = 0
+	if cc.has("no-cache") {
+		return
+	}
+	// 共享缓存优先使用s-maxage
+	if maxAge, ok := cc["s-maxage"]; ok {
+		if seconds, err := strconv.Atoi(maxAge); nil == err && seconds > 0 {
+			e.Lifetime = time.Duration(seconds) * time.Second
+		}
+		return
+	}
+	if maxAge, ok := cc["max-age"]; ok {
+		if seconds, err := strconv.Atoi(maxAge); nil == err && seconds > 0 {
+			e.Lifetime = time.Duration(seconds) * time.Second
+		}
+		return
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if nil != err {
+			return
+		}
+		if nil != dateErr {
+			date = now
+		}
+		e.Lifetime = t.Sub(date)
+	}
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.ResponseTime)
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return e.age(now) < e.Lifetime
+}
+
+func (e *cacheEntry) hasValidator() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// 存储时长：有效期剩余时间，可以重新验证时再加上StaleTTL
+func (e *cacheEntry) ttl(now time.Time, staleTTL time.Duration) time.Duration {
+	ttl := e.Lifetime - e.age(now)
+	if e.hasValidator() {
+		if ttl < 0 {
+			ttl = 0
+		}
+		ttl += staleTTL
+	}
+	return ttl
+}
+
+// 以304响应更新缓存
+func (e *cacheEntry) revalidate(httpResp *http.Response, now time.Time) {
+	for k, v := range httpResp.Header {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		e.Header[k] = append([]string(nil), v...)
+	}
+	e.setFreshness(now)
+}
+
+func (e *cacheEntry) response(now time.Time) *core.Response {
+	header := cloneHeader(e.Header)
+	header.Set("Age", strconv.Itoa(int(e.age(now)/time.Second)))
+	return core.NewResponse(e.StatusCode, header, append([]byte(nil), e.Body...))
+}
+
+// 条件请求，添加 If-None-Match 以及 If-Modified-Since 头部
+type conditionalRequest struct {
+	core.Request
+	entry *cacheEntry
+}
+
+func (r *conditionalRequest) HttpRequest() (*http.Request, error) {
+	httpReq, err := r.Request.HttpRequest()
+	if nil != err {
+		return nil, err
+	}
+	if etag := r.entry.Header.Get("ETag"); etag != "" && httpReq.Header.Get("If-None-Match") == "" {
+		httpReq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := r.entry.Header.Get("Last-Modified"); lastModified != "" && httpReq.Header.Get("If-Modified-Since") == "" {
+		httpReq.Header.Set("If-Modified-Since", lastModified)
+	}
+	return httpReq, nil
+}
+
+// 保留原请求的每一次尝试超时时间
+func (r *conditionalRequest) AttemptTimeOut() time.Duration {
+	if timeoutReq, ok := r.Request.(core.AttemptTimeOutRequest); ok {
+		return timeoutReq.AttemptTimeOut()
+	}
+	return -1
+}
+
+// 请求是否带有凭证
+func hasAuthorization(httpReq *http.Request) bool {
+	return nil != httpReq && httpReq.Header.Get("Authorization") != ""
+}
+
+func isCacheableRequest(httpReq *http.Request) bool {
+	return httpReq.Method == "" || httpReq.Method == http.MethodGet
+}
+
+func cacheKey(httpReq *http.Request) string {
+	method := httpReq.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	return method + " " + httpReq.URL.String()
+}
+
+// 变体的键，包括Vary指定的请求头部
+func cacheVariantKey(key string, vary []string, header http.Header) string {
+	if len(vary) == 0 {
+		return key
+	}
+	var b bytes.Buffer
+	b.WriteString(key)
+	for _, name := range vary {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(header[name], ","))
+	}
+	return b.String()
+}
+
+// 解析Vary头部，返回排序之后的规范头部名称
+func parseVary(header http.Header) []string {
+	var vary []string
+	for _, v := range header["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(vary)
+	return vary
+}
+
+type cacheControl map[string]string
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// 解析Cache-Control头部
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range header["Cache-Control"] {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, val := part, ""
+			if i := strings.Index(part, "="); i >= 0 {
+				name, val = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = val
+		}
+	}
+	return cc
+}
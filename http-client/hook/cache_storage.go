@@ -0,0 +1,145 @@
+package hook
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// 缓存存储接口，需要并发安全
+type CacheStorage interface {
+	// 获取缓存，不存在或者已经过期时返回false
+	Get(key string) ([]byte, bool)
+	// 保存缓存
+	// @params ttl 存储时长
+	Set(key string, val []byte, ttl time.Duration)
+	// 删除缓存
+	Delete(key string)
+}
+
+// 默认最大缓存数
+const defaultMemoryCacheMaxEntries = 1024
+
+// 内存缓存存储
+//   超过最大缓存数时淘汰最近最少使用的缓存（LRU）
+type MemoryCacheStorage struct {
+	mutex      sync.Mutex
+	maxEntries int
+	ll         *list.List
+	entries    map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key     string
+	val     []byte
+	expires time.Time
+}
+
+// 新建内存缓存存储
+// @params maxEntries 最大缓存数，小于等于零时默认为1024
+func NewMemoryCacheStorage(maxEntries int) *MemoryCacheStorage {
+	if maxEntries <= 0 {
+		maxEntries = defaultMemoryCacheMaxEntries
+	}
+	return &MemoryCacheStorage{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryCacheStorage) Get(key string) ([]byte, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*memoryCacheItem)
+	if time.Now().After(item.expires) {
+		m.removeElement(elem)
+		return nil, false
+	}
+	m.ll.MoveToFront(elem)
+	return item.val, true
+}
+
+func (m *MemoryCacheStorage) Set(key string, val []byte, ttl time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	expires := time.Now().Add(ttl)
+	if elem, ok := m.entries[key]; ok {
+		item := elem.Value.(*memoryCacheItem)
+		item.val, item.expires = val, expires
+		m.ll.MoveToFront(elem)
+		return
+	}
+	m.entries[key] = m.ll.PushFront(&memoryCacheItem{key: key, val: val, expires: expires})
+	for m.ll.Len() > m.maxEntries {
+		m.removeElement(m.ll.Back())
+	}
+}
+
+func (m *MemoryCacheStorage) Delete(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if elem, ok := m.entries[key]; ok {
+		m.removeElement(elem)
+	}
+}
+
+// 缓存数
+func (m *MemoryCacheStorage) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.ll.Len()
+}
+
+// 调用时需持有锁
+func (m *MemoryCacheStorage) removeElement(elem *list.Element) {
+	m.ll.Remove(elem)
+	delete(m.entries, elem.Value.(*memoryCacheItem).key)
+}
+
+// Redis客户端
+//   cache/mredis 中的 *RedisPool 实现了此接口
+type RedisCacheClient interface {
+	Get(key string) (string, error)
+	SetEx(key, val string, expired int64) error
+	Del(key string) (int, error)
+}
+
+// Redis缓存存储
+//   读取失败（包括key不存在）时视为没有缓存，写入失败时忽略
+type RedisCacheStorage struct {
+	client RedisCacheClient
+	prefix string
+}
+
+// 新建Redis缓存存储
+// @params client 如 mredis.NewRedisPool() 返回的连接池
+// @params prefix 键前缀，如 "http-cache:"
+func NewRedisCacheStorage(client RedisCacheClient, prefix string) *RedisCacheStorage {
+	return &RedisCacheStorage{client: client, prefix: prefix}
+}
+
+func (r *RedisCacheStorage) Get(key string) ([]byte, bool) {
+	val, err := r.client.Get(r.prefix + key)
+	if nil != err || val == "" {
+		return nil, false
+	}
+	return []byte(val), true
+}
+
+func (r *RedisCacheStorage) Set(key string, val []byte, ttl time.Duration) {
+	// SETEX以秒为单位，不足一秒按一秒计
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds <= 0 {
+		return
+	}
+	r.client.SetEx(r.prefix+key, string(val), seconds)
+}
+
+func (r *RedisCacheStorage) Delete(key string) {
+	r.client.Del(r.prefix + key)
+}
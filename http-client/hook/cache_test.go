@@ -0,0 +1,263 @@
+package hook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+type TestHeaderRequest struct {
+	TestRequest
+	Header http.Header
+}
+
+func (t *TestHeaderRequest) HttpRequest() (*http.Request, error) {
+	httpReq, err := t.TestRequest.HttpRequest()
+	if nil == err {
+		for k, v := range t.Header {
+			httpReq.Header[k] = v
+		}
+	}
+	return httpReq, err
+}
+
+// 模拟的Redis客户端
+type fakeRedis struct {
+	mutex sync.Mutex
+	data  map[string]string
+	ttl   map[string]int64
+}
+
+func (f *fakeRedis) Get(key string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	val, ok := f.data[key]
+	if !ok {
+		return "", errors.New("redigo: nil returned")
+	}
+	return val, nil
+}
+
+func (f *fakeRedis) SetEx(key, val string, expired int64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.data[key], f.ttl[key] = val, expired
+	return nil
+}
+
+func (f *fakeRedis) Del(key string) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.data, key)
+	return 1, nil
+}
+
+func newCacheServer(count *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(count, 1)
+		switch r.URL.Path {
+		case "/max-age":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.Header().Set("X-Revalidated", "1")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/last-modified":
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			if r.Header.Get("If-Modified-Since") != "" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/user":
+			// 按用户返回不同的内容
+			w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+			w.Write([]byte(r.Header.Get("Authorization") + ":"))
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			w.Write([]byte(r.Header.Get("Accept-Language") + ":"))
+		}
+		w.Write([]byte(strconv.Itoa(int(n))))
+	}))
+}
+
+func TestCacheHook_MaxAge(t *testing.T) {
+	var count int32
+	ts := newCacheServer(&count)
+	defer ts.Close()
+
+	client := core.NewClient("test", nil).AppendHook(NewCacheHook(CacheSettings{})).SetMaxBadRetryCount(1)
+	for i := 0; i < 3; i++ {
+		req := &TestRequest{RequestURL: ts.URL + "/max-age"}
+		resp, err := client.DoRequest(req)
+		if nil != err || resp.ToString() != "1" {
+			t.Fatal("DoRequest", i, err, resp.ToString())
+		}
+		if i > 0 && (CacheStatus(req) != CacheHit || req.ReqCount() != 0 || resp.Header.Get("Age") == "") {
+			t.Fatal("should hit cache", CacheStatus(req), req.ReqCount())
+		}
+	}
+	if atomic.LoadInt32(&count) != 1 {
+		t.Fatal("count", count)
+	}
+
+	// 请求要求重新验证
+	req := &TestHeaderRequest{TestRequest: TestRequest{RequestURL: ts.URL + "/max-age"},
+		Header: http.Header{"Cache-Control": {"no-cache"}}}
+	if resp, err := client.DoRequest(req); nil != err || resp.ToString() != "2" || CacheStatus(req) != CacheMiss {
+		t.Fatal("no-cache request", err, CacheStatus(req))
+	}
+	// 请求不使用缓存
+	req.Header.Set("Cache-Control", "no-store")
+	if resp, err := client.DoRequest(req); nil != err || resp.ToString() != "3" || CacheStatus(req) != CacheBypass {
+		t.Fatal("no-store request", err, CacheStatus(req))
+	}
+}
+
+func TestCacheHook_Revalidate(t *testing.T) {
+	var count int32
+	ts := newCacheServer(&count)
+	defer ts.Close()
+
+	client := core.NewClient("test", nil).AppendHook(NewCacheHook(CacheSettings{})).SetMaxBadRetryCount(1)
+	for _, path := range []string{"/etag", "/last-modified"} {
+		atomic.StoreInt32(&count, 0)
+		req := &TestRequest{RequestURL: ts.URL + path}
+		if resp, err := client.DoRequest(req); nil != err || resp.ToString() != "1" || CacheStatus(req) != CacheMiss {
+			t.Fatal(path, err, CacheStatus(req))
+		}
+		resp, err := client.DoRequest(req)
+		if nil != err || resp.StatusCode != http.StatusOK || resp.ToString() != "1" {
+			t.Fatal(path, "revalidate", err)
+		}
+		if CacheStatus(req) != CacheRevalidated || req.ReqCount() != 1 || atomic.LoadInt32(&count) != 2 {
+			t.Fatal(path, "should revalidate", CacheStatus(req), count)
+		}
+		if resp.Source() != core.SourceNetwork {
+			t.Fatal(path, "revalidated response should come from network", resp.Source())
+		}
+		if path == "/etag" && resp.Header.Get("X-Revalidated") != "1" {
+			t.Fatal("304 headers should be merged", resp.Header)
+		}
+	}
+}
+
+func TestCacheHook_NoStoreAndVary(t *testing.T) {
+	var count int32
+	ts := newCacheServer(&count)
+	defer ts.Close()
+
+	client := core.NewClient("test", nil).AppendHook(NewCacheHook(CacheSettings{})).SetMaxBadRetryCount(1)
+	for i := 1; i <= 2; i++ {
+		req := &TestRequest{RequestURL: ts.URL + "/no-store"}
+		if resp, err := client.DoRequest(req); nil != err || resp.ToString() != strconv.Itoa(i) {
+			t.Fatal("no-store response should not be cached", err)
+		}
+	}
+
+	atomic.StoreInt32(&count, 0)
+	get := func(lang string) string {
+		req := &TestHeaderRequest{TestRequest: TestRequest{RequestURL: ts.URL + "/vary"},
+			Header: http.Header{"Accept-Language": {lang}}}
+		resp, err := client.DoRequest(req)
+		if nil != err {
+			t.Fatal("vary", err)
+		}
+		return resp.ToString()
+	}
+	if get("en") != "en:1" || get("zh") != "zh:2" || get("en") != "en:1" || get("zh") != "zh:2" {
+		t.Fatal("vary should cache variants")
+	}
+}
+
+func TestCacheHook_Credentials(t *testing.T) {
+	var count int32
+	ts := newCacheServer(&count)
+	defer ts.Close()
+
+	redis := &fakeRedis{data: map[string]string{}, ttl: map[string]int64{}}
+	storage := NewRedisCacheStorage(redis, "http:")
+	get := func(token, cc string) string {
+		// 两个客户端共享Redis缓存，令牌在BeforeSend中添加
+		client := core.NewClient("test", nil).SetMaxBadRetryCount(1).
+			AppendHook(NewCacheHook(CacheSettings{Storage: storage}), NewBearerTokenHook(StaticTokenSource(token), 0))
+		resp, err := client.DoRequest(&TestRequest{RequestURL: ts.URL + "/user?cc=" + url.QueryEscape(cc)})
+		if nil != err {
+			t.Fatal("DoRequest", err)
+		}
+		return resp.ToString()
+	}
+	for _, cc := range []string{"max-age=60", "private, max-age=60"} {
+		for _, token := range []string{"a", "b", "a"} {
+			if body := get(token, cc); !strings.HasPrefix(body, "Bearer "+token+":") {
+				t.Fatal(cc, "response to request with credentials should not be shared", token, body)
+			}
+		}
+	}
+	if len(redis.data) != 0 {
+		t.Fatal("nothing should be stored", redis.data)
+	}
+	// 明确允许共享缓存
+	for _, cc := range []string{"public, max-age=60", "s-maxage=60"} {
+		if body := get("a", cc); get("b", cc) != body {
+			t.Fatal(cc, "public response should be cached")
+		}
+	}
+}
+
+func TestCacheHook_RedisStorage(t *testing.T) {
+	var count int32
+	ts := newCacheServer(&count)
+	defer ts.Close()
+
+	redis := &fakeRedis{data: map[string]string{}, ttl: map[string]int64{}}
+	cacheHook := NewCacheHook(CacheSettings{Storage: NewRedisCacheStorage(redis, "http:"), StaleTTL: time.Minute})
+	client := core.NewClient("test", nil).AppendHook(cacheHook).SetMaxBadRetryCount(1)
+	for i := 0; i < 2; i++ {
+		req := &TestRequest{RequestURL: ts.URL + "/max-age"}
+		if resp, err := client.DoRequest(req); nil != err || resp.ToString() != "1" {
+			t.Fatal("DoRequest", err)
+		}
+	}
+	key := "http:GET " + ts.URL + "/max-age"
+	if _, ok := redis.data[key]; !ok || redis.ttl[key] <= 0 || redis.ttl[key] > 60 {
+		t.Fatal("redis", redis.ttl)
+	}
+}
+
+func TestMemoryCacheStorage(t *testing.T) {
+	storage := NewMemoryCacheStorage(2)
+	storage.Set("a", []byte("a"), time.Minute)
+	storage.Set("b", []byte("b"), time.Minute)
+	storage.Get("a")
+	storage.Set("c", []byte("c"), time.Minute)
+	if _, ok := storage.Get("b"); ok || storage.Len() != 2 {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	if val, ok := storage.Get("a"); !ok || string(val) != "a" {
+		t.Fatal("Get a")
+	}
+	storage.Set("d", []byte("d"), -time.Second)
+	if _, ok := storage.Get("d"); ok {
+		t.Fatal("expired entry")
+	}
+	storage.Delete("a")
+	if _, ok := storage.Get("a"); ok {
+		t.Fatal("Delete")
+	}
+}
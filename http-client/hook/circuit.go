@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"errors"
 	"github.com/BPing/go-toolkit/http-client/core"
+	"net/http"
 	"sync"
 	"time"
 )
@@ -23,6 +24,11 @@ var (
 	ErrOpenState       = errors.New("circuit breaker is open")
 )
 
+// JSON等文本格式输出状态名
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 func (s State) String() string {
 	switch s {
 	case StateClosed:
@@ -37,12 +43,34 @@ func (s State) String() string {
 }
 
 // 记录请求的数量以及失败和成功数量
+//
+// Window开头的字段为滑动窗口内的统计数据，只有配置了CircuitSettings.Window才有效
 type Counts struct {
 	Requests             uint32
 	TotalSuccesses       uint32
 	TotalFailures        uint32
 	ConsecutiveSuccesses uint32
 	ConsecutiveFailures  uint32
+
+	WindowRequests  uint32
+	WindowFailures  uint32
+	WindowSlowCalls uint32
+}
+
+// 滑动窗口内的失败率（慢请求也算作失败）
+func (c Counts) FailureRate() float64 {
+	if c.WindowRequests == 0 {
+		return 0
+	}
+	return float64(c.WindowFailures) / float64(c.WindowRequests)
+}
+
+// 滑动窗口内的慢请求率
+func (c Counts) SlowCallRate() float64 {
+	if c.WindowRequests == 0 {
+		return 0
+	}
+	return float64(c.WindowSlowCalls) / float64(c.WindowRequests)
 }
 
 func (c *Counts) onRequest() {
@@ -71,82 +99,127 @@ func (c *Counts) clear() {
 
 // 断路器钩子
 type CircuitHook struct {
-	// cd 断路器map，保存不同服务器的断路器实例。
-	cb map[string]*CircuitBreaker
+	// cb 断路器注册表，保存不同服务器的断路器实例。并发安全
+	cb *breakerRegistry
 
 	// setting 断路器配置，请查看结构体CircuitSettings。
 	//         注意：Name 配置不起作用，因为钩子内部将会改变它。
 	settings CircuitSettings
 
 	//自定义失败。可以根据返回内容，自定义归类为失败请求.
-	//@notice 不建议使用
+	//@notice 不建议使用，请使用CircuitSettings.IsFailure
 	//@params cErr 原始错误信息
 	//@params req  请求结构体。
 	//@return 返回的错误替换原来的错误
 	handleCErr func(cErr error, req core.Request) error
+
+	// 断路器拒绝请求（ErrOpenState、ErrTooManyRequests）时的降级处理
+	fallback core.FallbackFunc
+}
+
+// 设置降级处理
+// 断路器拒绝请求时，以降级处理返回的响应代替错误返回给调用者
+// 可以使用StaticFallback()、LastGoodFallback等，或者自定义函数
+func (ch *CircuitHook) SetFallback(fallback core.FallbackFunc) {
+	ch.fallback = fallback
+}
+
+// 实现core.FallbackHook
+func (ch *CircuitHook) Fallback(err error, req core.Request, client core.Client) (*core.Response, bool) {
+	if nil == ch.fallback || (err != ErrOpenState && err != ErrTooManyRequests) {
+		return nil, false
+	}
+	return ch.fallback(err, req)
 }
 
 func (ch *CircuitHook) SetHandleCErr(handleFunc func(cErr error, req core.Request) error) {
 	ch.handleCErr = handleFunc
 }
 
+// 设置断路器空闲时间
+// 超过空闲时间没有使用的断路器将被清理（Open状态以及被强制设置状态的除外），
+// 适用于服务名很多的场景。为零时不清理（默认）
+func (ch *CircuitHook) SetIdleTimeout(idleTimeout time.Duration) {
+	ch.cb.setIdleTimeout(idleTimeout)
+}
+
 const CircuitHookKey = "CircuitHook"
 
-func (ch *CircuitHook) getGeneration(req core.Request) uint64 {
-	generation, ok := req.HookData(CircuitHookKey)
+// 请求开始时使用的断路器以及状态改变标识
+// 即使断路器在请求过程中被清理，请求结束时也能找到它
+type circuitHookData struct {
+	cb         *CircuitBreaker
+	generation uint64
+}
+
+func (ch *CircuitHook) getHookData(req core.Request) (circuitHookData, bool) {
+	data, ok := req.HookData(CircuitHookKey)
 	if !ok {
-		return 0
+		return circuitHookData{}, false
 	}
-	return generation.(uint64)
+	hookData, ok := data.(circuitHookData)
+	return hookData, ok
 }
 
-func (ch *CircuitHook) setGeneration(req core.Request, generation uint64) (ok bool) {
-	ok = req.SetHookData(CircuitHookKey, generation)
+func (ch *CircuitHook) setHookData(req core.Request, cb *CircuitBreaker, generation uint64) (ok bool) {
+	ok = req.SetHookData(CircuitHookKey, circuitHookData{cb: cb, generation: generation})
 	return
 }
 
-func (ch *CircuitHook) getCircuitBreaker(req core.Request, ifCreate bool) (*CircuitBreaker, error) {
-	cb, ok := ch.cb[req.ServerName()]
-	if ok {
-		return cb, nil
-	}
+func (ch *CircuitHook) getCircuitBreaker(req core.Request) *CircuitBreaker {
+	return ch.cb.getOrCreate(req.ServerName())
+}
+
+func (ch *CircuitHook) newCircuitBreaker(name string) *CircuitBreaker {
 	setting := ch.settings.Clone().(*CircuitSettings)
-	setting.Name = req.ServerName()
-	cb = NewCircuitBreaker(*setting)
-	ch.cb[req.ServerName()] = cb
-	return cb, nil
+	setting.Name = name
+	return NewCircuitBreaker(*setting)
 }
 
 func (ch *CircuitHook) BeforeRequest(req core.Request, client core.Client) error {
-	cb, _ := ch.getCircuitBreaker(req, true)
+	// 清理上一次请求的数据，被拒绝的请求不会在AfterRequest中计量
+	req.SetHookData(CircuitHookKey, nil)
+	cb := ch.getCircuitBreaker(req)
 	generation, err := cb.beforeRequest()
 	if nil != err {
 		return err
 	}
-	ch.setGeneration(req, generation)
+	ch.setHookData(req, cb, generation)
 	return nil
 }
 
 func (ch *CircuitHook) AfterRequest(cErr error, req core.Request, client core.Client) {
-	cb, err := ch.getCircuitBreaker(req, false)
-	if nil != err {
+	hookData, ok := ch.getHookData(req)
+	if !ok {
 		return
 	}
-	generation := ch.getGeneration(req)
+	cb, generation := hookData.cb, hookData.generation
+	// 每次请求只计量一次
+	req.SetHookData(CircuitHookKey, nil)
 
+	if req.ReqCount() == 0 {
+		// 请求没有发送（被之后的钩子拒绝，或者由钩子直接响应，如缓存命中），不计量结果
+		cb.cancelRequest(generation)
+		return
+	}
 	if nil != ch.handleCErr {
 		// 对请求错误重新定义
 		cErr = ch.handleCErr(cErr, req)
 	}
-	cb.afterRequest(generation, cErr == nil)
+	isFailure := ch.settings.IsFailure
+	if nil == isFailure {
+		isFailure = DefaultIsFailure
+	}
+	cb.afterRequestLong(generation, !isFailure(cErr, req.Response()), req.ReqLongTime())
 }
 
 func NewCircuitHook(settings CircuitSettings) *CircuitHook {
-	return &CircuitHook{
+	ch := &CircuitHook{
 		settings:   settings,
-		cb:         make(map[string]*CircuitBreaker),
 		handleCErr: nil,
 	}
+	ch.cb = newBreakerRegistry(ch.newCircuitBreaker)
+	return ch
 }
 
 // Name  名字，请务必保障名字的唯一性
@@ -162,9 +235,28 @@ func NewCircuitHook(settings CircuitSettings) *CircuitHook {
 // ReadyToTrip   测试是否应该从Closed状态转变为Open状态。
 //               true 表示可以转变，否则不可以。
 //               如果不配置，则采用默认的。默认失败次数达到5次则进入Open状
+//               滑动窗口模式下，默认根据失败率以及慢请求率判断
 //
 // OnStateChange 状态变化将调用此方法。
 //
+// Window 滑动窗口时间（Closed状态下有效）。如果为零，不启用滑动窗口模式。
+//        启用之后，Counts中Window开头的字段为最近Window时间内的统计数据
+//
+// WindowBuckets 滑动窗口分桶数量，如60秒分成10个桶。如果为零，默认为10
+//
+// MinRequests 窗口内最小请求数，请求数不足时不会进入Open状态
+//
+// FailureRateThreshold 失败率阈值（0~1），达到阈值进入Open状态。
+//                      如果和SlowCallRateThreshold都为零，默认为0.5
+//
+// SlowCallThreshold 慢请求时间，处理时间（ReqLongTime()）超过它的请求算作失败。如果为零，不统计慢请求
+//
+// SlowCallRateThreshold 慢请求率阈值（0~1），达到阈值进入Open状态。如果为零，不根据慢请求率判断
+//
+// IsFailure 判断请求是否失败（只对CircuitHook有效）。
+//           可以根据响应状态码以及头部信息归类失败请求。
+//           如果不配置，则采用默认的DefaultIsFailure：请求错误、5xx以及429算作失败
+//
 type CircuitSettings struct {
 	Name          string
 	MaxRequests   uint32
@@ -172,6 +264,15 @@ type CircuitSettings struct {
 	Timeout       time.Duration
 	ReadyToTrip   func(counts Counts) bool
 	OnStateChange func(name string, from State, to State)
+
+	Window                time.Duration
+	WindowBuckets         int
+	MinRequests           uint32
+	FailureRateThreshold  float64
+	SlowCallThreshold     time.Duration
+	SlowCallRateThreshold float64
+
+	IsFailure func(cErr error, resp *core.Response) bool
 }
 
 func (set *CircuitSettings) Clone() interface{} {
@@ -179,7 +280,25 @@ func (set *CircuitSettings) Clone() interface{} {
 	return &new_obj
 }
 
-const defaultTimeout = time.Minute
+const (
+	defaultTimeout = time.Minute
+
+	// 滑动窗口模式下默认失败率阈值
+	defaultFailureRateThreshold = 0.5
+)
+
+// 默认的失败判断
+// 请求错误、5xx以及429（Too Many Requests）算作失败，
+// 没有响应时只根据请求错误判断
+func DefaultIsFailure(cErr error, resp *core.Response) bool {
+	if nil != cErr {
+		return true
+	}
+	if nil == resp || nil == resp.Response {
+		return false
+	}
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
 
 func defaultReadyToTrip(counts Counts) bool {
 	return counts.ConsecutiveFailures > 5
@@ -196,6 +315,10 @@ type CircuitBreaker struct {
 	readyToTrip   func(counts Counts) bool
 	onStateChange func(name string, from State, to State)
 
+	// 滑动窗口，为nil时不启用
+	window            *slidingWindow
+	slowCallThreshold time.Duration
+
 	mutex  sync.Mutex
 	state  State
 	counts Counts
@@ -205,6 +328,20 @@ type CircuitBreaker struct {
 	// 相当于状态改变标识。
 	// 避免前一个的数据污染现在的
 	generation uint64
+
+	// 是否被强制设置状态
+	// 强制状态下，状态不会自动转变，直到调用Release()
+	forced bool
+}
+
+// 断路器快照
+type BreakerSnapshot struct {
+	Name       string    `json:"name"`
+	State      State     `json:"state"`
+	Counts     Counts    `json:"counts"`
+	Expiry     time.Time `json:"expiry"`
+	Generation uint64    `json:"generation"`
+	Forced     bool      `json:"forced"`
 }
 
 func NewCircuitBreaker(st CircuitSettings) *CircuitBreaker {
@@ -224,10 +361,16 @@ func NewCircuitBreaker(st CircuitSettings) *CircuitBreaker {
 	} else {
 		cb.timeout = st.Timeout
 	}
-	if st.ReadyToTrip == nil {
-		cb.readyToTrip = defaultReadyToTrip
-	} else {
+	if st.Window > 0 {
+		cb.window = newSlidingWindow(st.Window, st.WindowBuckets)
+	}
+	cb.slowCallThreshold = st.SlowCallThreshold
+	if st.ReadyToTrip != nil {
 		cb.readyToTrip = st.ReadyToTrip
+	} else if nil != cb.window {
+		cb.readyToTrip = windowReadyToTrip(st)
+	} else {
+		cb.readyToTrip = defaultReadyToTrip
 	}
 	cb.reset(time.Now())
 	return cb
@@ -247,6 +390,73 @@ func (cb *CircuitBreaker) State() State {
 	return state
 }
 
+// 返回当前的计量数据
+func (cb *CircuitBreaker) Counts() Counts {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := time.Now()
+	cb.currentState(now)
+	return cb.countsAt(now)
+}
+
+// 返回断路器快照
+func (cb *CircuitBreaker) Snapshot() BreakerSnapshot {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := time.Now()
+	state, generation := cb.currentState(now)
+	return BreakerSnapshot{
+		Name:       cb.name,
+		State:      state,
+		Counts:     cb.countsAt(now),
+		Expiry:     cb.expiry,
+		Generation: generation,
+		Forced:     cb.forced,
+	}
+}
+
+// 强制打开断路器，所有请求将被拒绝，直到调用Release()
+func (cb *CircuitBreaker) ForceOpen() {
+	cb.force(StateOpen)
+}
+
+// 强制关闭断路器，所有请求都可以通过，直到调用Release()
+func (cb *CircuitBreaker) ForceClose() {
+	cb.force(StateClosed)
+}
+
+// 解除强制状态，断路器重置为Closed状态
+func (cb *CircuitBreaker) Release() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := time.Now()
+	cb.forced = false
+	if cb.state == StateClosed {
+		cb.reset(now)
+	} else {
+		cb.setState(StateClosed, now)
+	}
+}
+
+func (cb *CircuitBreaker) force(state State) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := time.Now()
+	cb.forced = false
+	if cb.state == state {
+		cb.reset(now)
+	} else {
+		cb.setState(state, now)
+	}
+	cb.forced = true
+	// 强制状态下不会过期
+	cb.expiry = time.Time{}
+}
+
 func (cb *CircuitBreaker) beforeRequest() (uint64, error) {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
@@ -264,7 +474,25 @@ func (cb *CircuitBreaker) beforeRequest() (uint64, error) {
 	return generation, nil
 }
 
+// 取消请求（请求没有发送），归还Half-Open状态下的请求名额
+func (cb *CircuitBreaker) cancelRequest(before uint64) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	_, generation := cb.currentState(time.Now())
+	if generation != before || cb.counts.Requests == 0 {
+		return
+	}
+	cb.counts.Requests--
+}
+
 func (cb *CircuitBreaker) afterRequest(before uint64, success bool) {
+	cb.afterRequestLong(before, success, 0)
+}
+
+// 请求结束
+// @params long 请求处理时间，超过慢请求时间的请求算作失败
+func (cb *CircuitBreaker) afterRequestLong(before uint64, success bool, long time.Duration) {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
 
@@ -274,6 +502,14 @@ func (cb *CircuitBreaker) afterRequest(before uint64, success bool) {
 		return
 	}
 
+	slow := cb.slowCallThreshold > 0 && long > cb.slowCallThreshold
+	if slow {
+		success = false
+	}
+	if nil != cb.window && state == StateClosed {
+		cb.window.add(now, !success, slow)
+	}
+
 	if success {
 		cb.onSuccess(state, now)
 	} else {
@@ -282,6 +518,9 @@ func (cb *CircuitBreaker) afterRequest(before uint64, success bool) {
 }
 
 func (cb *CircuitBreaker) currentState(now time.Time) (State, uint64) {
+	if cb.forced {
+		return cb.state, cb.generation
+	}
 	switch cb.state {
 	case StateClosed:
 		if !cb.expiry.IsZero() && cb.expiry.Before(now) {
@@ -296,6 +535,10 @@ func (cb *CircuitBreaker) currentState(now time.Time) (State, uint64) {
 }
 
 func (cb *CircuitBreaker) onSuccess(state State, now time.Time) {
+	if cb.forced {
+		cb.counts.onSuccess()
+		return
+	}
 	switch state {
 	case StateClosed:
 		cb.counts.onSuccess()
@@ -308,10 +551,14 @@ func (cb *CircuitBreaker) onSuccess(state State, now time.Time) {
 }
 
 func (cb *CircuitBreaker) onFailure(state State, now time.Time) {
+	if cb.forced {
+		cb.counts.onFailure()
+		return
+	}
 	switch state {
 	case StateClosed:
 		cb.counts.onFailure()
-		if cb.readyToTrip(cb.counts) {
+		if cb.readyToTrip(cb.countsAt(now)) {
 			cb.setState(StateOpen, now)
 		}
 	case StateHalfOpen:
@@ -328,12 +575,24 @@ func (cb *CircuitBreaker) setState(state State, now time.Time) {
 	cb.state = state
 
 	cb.reset(now)
+	if nil != cb.window {
+		cb.window.clear()
+	}
 
 	if cb.onStateChange != nil {
 		cb.onStateChange(cb.name, prev, state)
 	}
 }
 
+// 返回计量数据，包括滑动窗口内的统计数据
+func (cb *CircuitBreaker) countsAt(now time.Time) Counts {
+	counts := cb.counts
+	if nil != cb.window {
+		counts.WindowRequests, counts.WindowFailures, counts.WindowSlowCalls = cb.window.totals(now)
+	}
+	return counts
+}
+
 // 重置. 状态改变之后重置数据
 //     1、清理计量器;
 //     2、重置过期时间
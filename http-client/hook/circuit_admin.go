@@ -0,0 +1,100 @@
+package hook
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// 返回指定服务的断路器
+func (ch *CircuitHook) Breaker(serverName string) (*CircuitBreaker, bool) {
+	return ch.cb.get(serverName)
+}
+
+// 返回所有断路器，以服务名（ServerName()）为键
+func (ch *CircuitHook) Breakers() map[string]*CircuitBreaker {
+	all := ch.cb.all()
+	breakers := make(map[string]*CircuitBreaker, len(all))
+	for _, cb := range all {
+		breakers[cb.Name()] = cb
+	}
+	return breakers
+}
+
+// 移除指定服务的断路器，下一次请求时重新创建
+func (ch *CircuitHook) RemoveBreaker(serverName string) {
+	ch.cb.remove(serverName)
+}
+
+// 返回所有断路器的快照，按服务名排序
+func (ch *CircuitHook) Snapshots() []BreakerSnapshot {
+	all := ch.cb.all()
+	snapshots := make([]BreakerSnapshot, 0, len(all))
+	for _, cb := range all {
+		snapshots = append(snapshots, cb.Snapshot())
+	}
+	return snapshots
+}
+
+// 断路器管理接口
+//   GET                      返回所有断路器的快照
+//   GET  ?name=服务名         返回指定断路器的快照
+//   POST ?name=服务名&action= 强制改变断路器状态并返回快照，断路器不存在时新建。
+//                            action: open 强制打开；close 强制关闭；release 解除强制状态
+//
+// example:
+//
+//	http.Handle("/debug/circuit", circuitHook.Handler())
+func (ch *CircuitHook) Handler() http.Handler {
+	return http.HandlerFunc(ch.serveHTTP)
+}
+
+func (ch *CircuitHook) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	switch r.Method {
+	case http.MethodGet:
+		if name == "" {
+			writeJSON(w, http.StatusOK, ch.Snapshots())
+			return
+		}
+		cb, ok := ch.Breaker(name)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "circuit breaker not found: "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, cb.Snapshot())
+	case http.MethodPost:
+		if name == "" {
+			writeJSONError(w, http.StatusBadRequest, "name is required")
+			return
+		}
+		action := r.URL.Query().Get("action")
+		if action != "open" && action != "close" && action != "release" {
+			writeJSONError(w, http.StatusBadRequest, "unknown action: "+action)
+			return
+		}
+		// 还没有请求的服务同样可以强制改变状态，如在故障之前打开断路器
+		cb := ch.cb.getOrCreate(name)
+		switch action {
+		case "open":
+			cb.ForceOpen()
+		case "close":
+			cb.ForceClose()
+		case "release":
+			cb.Release()
+		}
+		writeJSON(w, http.StatusOK, cb.Snapshot())
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,113 @@
+package hook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+func TestCircuitBreaker_Force(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitSettings{Name: "test"})
+
+	breaker.ForceOpen()
+	if _, err := breaker.beforeRequest(); err != ErrOpenState {
+		t.Fatal("ForceOpen", err)
+	}
+	snapshot := breaker.Snapshot()
+	if snapshot.State != StateOpen || !snapshot.Forced || !snapshot.Expiry.IsZero() {
+		t.Fatal("ForceOpen snapshot", snapshot)
+	}
+
+	breaker.ForceClose()
+	for i := 0; i < 10; i++ {
+		generation, err := breaker.beforeRequest()
+		if err != nil {
+			t.Fatal("ForceClose", err)
+		}
+		breaker.afterRequest(generation, false)
+	}
+	if breaker.State() != StateClosed || breaker.Counts().ConsecutiveFailures != 10 {
+		t.Fatal("ForceClose should not trip", breaker.State(), breaker.Counts())
+	}
+
+	breaker.Release()
+	snapshot = breaker.Snapshot()
+	if snapshot.State != StateClosed || snapshot.Forced || snapshot.Counts.Requests != 0 {
+		t.Fatal("Release", snapshot)
+	}
+}
+
+func TestCircuitHook_Handler(t *testing.T) {
+	circuitHook := NewCircuitHook(CircuitSettings{})
+	c := core.NewClient("test", nil).AppendHook(circuitHook)
+	c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "b"})
+	c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "a"})
+
+	handler := circuitHook.Handler()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var snapshots []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &snapshots); err != nil {
+		t.Fatal("json", err, rec.Body.String())
+	}
+	if len(snapshots) != 2 || snapshots[0]["name"] != "a" || snapshots[0]["state"] != "closed" {
+		t.Fatal("list", rec.Body.String())
+	}
+	counts := snapshots[0]["counts"].(map[string]interface{})
+	if counts["TotalFailures"].(float64) != 1 {
+		t.Fatal("counts", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/?name=a&action=open", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatal("open", rec.Code, rec.Body.String())
+	}
+	_, err := c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "a"})
+	if err != ErrOpenState {
+		t.Fatal("forced open", err)
+	}
+	if cb, _ := circuitHook.Breaker("a"); cb.State() != StateOpen {
+		t.Fatal("state", cb.State())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/?name=a", nil))
+	var snapshot map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &snapshot)
+	if snapshot["state"] != "open" || snapshot["forced"] != true {
+		t.Fatal("get", rec.Body.String())
+	}
+
+	for target, code := range map[string]int{
+		"/?name=a&action=release": http.StatusOK,
+		"/?name=y&action=boom":    http.StatusBadRequest,
+		"/?action=open":           http.StatusBadRequest,
+		"/?name=x&action=open":    http.StatusOK,
+	} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", target, nil))
+		if rec.Code != code {
+			t.Fatal(target, rec.Code, rec.Body.String())
+		}
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatal("DELETE", rec.Code)
+	}
+	if cb, _ := circuitHook.Breaker("a"); cb.State() != StateClosed {
+		t.Fatal("release", cb.State())
+	}
+	// 还没有请求的服务，强制打开之后拒绝请求
+	_, err = c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "x"})
+	if err != ErrOpenState {
+		t.Fatal("breaker opened before any traffic", err)
+	}
+	if _, ok := circuitHook.Breaker("y"); ok {
+		t.Fatal("invalid action should not create a breaker")
+	}
+}
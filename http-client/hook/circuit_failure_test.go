@@ -0,0 +1,82 @@
+package hook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+func TestDefaultIsFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(r.URL.Query().Get("code"))
+		w.WriteHeader(code)
+	}))
+	defer ts.Close()
+
+	cases := map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusNoContent:           false,
+		http.StatusNotModified:         false,
+		http.StatusBadRequest:          false,
+		http.StatusNotFound:            false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+	}
+	for code, failure := range cases {
+		circuitHook := NewCircuitHook(CircuitSettings{})
+		c := core.NewClient("test", nil).AppendHook(circuitHook)
+		c.SetMaxBadRetryCount(1)
+		req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL + "?code=" + strconv.Itoa(code)}, Server: "test"}
+		if _, err := c.DoRequest(req); err != nil {
+			t.Fatal(code, err)
+		}
+		cb, _ := circuitHook.Breaker("test")
+		if counts := cb.Counts(); (counts.TotalFailures == 1) != failure {
+			t.Fatal(code, "failure", failure, counts)
+		}
+	}
+
+	// 请求错误
+	circuitHook := NewCircuitHook(CircuitSettings{})
+	c := core.NewClient("test", nil).AppendHook(circuitHook)
+	c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "test"})
+	if cb, _ := circuitHook.Breaker("test"); cb.Counts().TotalFailures != 1 {
+		t.Fatal("error", cb.Counts())
+	}
+	if DefaultIsFailure(nil, nil) || DefaultIsFailure(nil, &core.Response{}) {
+		t.Fatal("no response")
+	}
+}
+
+func TestCircuitSettings_IsFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Error-Code", r.URL.Query().Get("error"))
+	}))
+	defer ts.Close()
+
+	circuitHook := NewCircuitHook(CircuitSettings{
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.ConsecutiveFailures >= 2
+		},
+		IsFailure: func(cErr error, resp *core.Response) bool {
+			return cErr != nil || resp.Header.Get("X-Error-Code") != ""
+		},
+	})
+	c := core.NewClient("test", nil).AppendHook(circuitHook)
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "test"}
+	c.DoRequest(req)
+	if cb, _ := circuitHook.Breaker("test"); cb.Counts().TotalSuccesses != 1 {
+		t.Fatal("success", cb.Counts())
+	}
+	req.RequestURL = ts.URL + "?error=1001"
+	c.DoRequest(req)
+	c.DoRequest(req)
+	if _, err := c.DoRequest(req); err != ErrOpenState {
+		t.Fatal("IsFailure", err)
+	}
+}
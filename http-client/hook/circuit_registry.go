@@ -0,0 +1,151 @@
+package hook
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 断路器注册表
+//   并发安全，按服务名保存断路器实例。
+//   设置了空闲时间时，长时间没有使用的断路器将被清理，
+//   以免服务名过多（如以请求主机为服务名）时无限增长。
+//   处于Open状态或者被强制设置状态的断路器不会被清理。
+type breakerRegistry struct {
+	mutex    sync.RWMutex
+	breakers map[string]*breakerEntry
+
+	// 空闲时间，为零时不清理
+	idleTimeout time.Duration
+	// 上一次清理时间（UnixNano）
+	lastSweep int64
+
+	newBreaker func(name string) *CircuitBreaker
+}
+
+type breakerEntry struct {
+	cb *CircuitBreaker
+	// 最近一次使用时间（UnixNano）
+	lastUsed int64
+}
+
+func newBreakerRegistry(newBreaker func(name string) *CircuitBreaker) *breakerRegistry {
+	return &breakerRegistry{
+		breakers:   make(map[string]*breakerEntry),
+		newBreaker: newBreaker,
+		lastSweep:  time.Now().UnixNano(),
+	}
+}
+
+// 获取断路器，不存在时新建
+func (r *breakerRegistry) getOrCreate(name string) *CircuitBreaker {
+	now := time.Now()
+	r.maybeSweep(now)
+
+	r.mutex.RLock()
+	entry, ok := r.breakers[name]
+	r.mutex.RUnlock()
+	if ok {
+		atomic.StoreInt64(&entry.lastUsed, now.UnixNano())
+		return entry.cb
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if entry, ok = r.breakers[name]; ok {
+		atomic.StoreInt64(&entry.lastUsed, now.UnixNano())
+		return entry.cb
+	}
+	entry = &breakerEntry{cb: r.newBreaker(name), lastUsed: now.UnixNano()}
+	r.breakers[name] = entry
+	return entry.cb
+}
+
+// 获取断路器，不存在时返回false
+func (r *breakerRegistry) get(name string) (*CircuitBreaker, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	entry, ok := r.breakers[name]
+	if !ok {
+		return nil, false
+	}
+	return entry.cb, true
+}
+
+// 移除断路器
+func (r *breakerRegistry) remove(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.breakers, name)
+}
+
+// 返回所有断路器，按服务名排序
+func (r *breakerRegistry) all() []*CircuitBreaker {
+	r.mutex.RLock()
+	breakers := make([]*CircuitBreaker, 0, len(r.breakers))
+	for _, entry := range r.breakers {
+		breakers = append(breakers, entry.cb)
+	}
+	r.mutex.RUnlock()
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].Name() < breakers[j].Name() })
+	return breakers
+}
+
+func (r *breakerRegistry) len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.breakers)
+}
+
+func (r *breakerRegistry) setIdleTimeout(idleTimeout time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.idleTimeout = idleTimeout
+}
+
+// 每隔一半的空闲时间清理一次
+func (r *breakerRegistry) maybeSweep(now time.Time) {
+	r.mutex.RLock()
+	idleTimeout := r.idleTimeout
+	r.mutex.RUnlock()
+	if idleTimeout <= 0 {
+		return
+	}
+	last := atomic.LoadInt64(&r.lastSweep)
+	if now.UnixNano()-last < int64(idleTimeout/2) {
+		return
+	}
+	// 只允许一个goroutine执行清理
+	if !atomic.CompareAndSwapInt64(&r.lastSweep, last, now.UnixNano()) {
+		return
+	}
+	r.sweep(now, idleTimeout)
+}
+
+func (r *breakerRegistry) sweep(now time.Time, idleTimeout time.Duration) {
+	deadline := now.Add(-idleTimeout).UnixNano()
+	// 只在锁内找出空闲的断路器。
+	// Snapshot()可能改变断路器的状态并同步调用OnStateChange，回调中可能访问注册表（如Breaker()），不能持有锁
+	r.mutex.RLock()
+	idle := make(map[string]*breakerEntry)
+	for name, entry := range r.breakers {
+		if atomic.LoadInt64(&entry.lastUsed) < deadline {
+			idle[name] = entry
+		}
+	}
+	r.mutex.RUnlock()
+
+	for name, entry := range idle {
+		snapshot := entry.cb.Snapshot()
+		if snapshot.State == StateOpen || snapshot.Forced {
+			continue
+		}
+		r.mutex.Lock()
+		// 检查期间可能已经被使用或者移除
+		if r.breakers[name] == entry && atomic.LoadInt64(&entry.lastUsed) < deadline {
+			delete(r.breakers, name)
+		}
+		r.mutex.Unlock()
+	}
+}
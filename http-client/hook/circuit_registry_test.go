@@ -0,0 +1,133 @@
+package hook
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+func TestCircuitHook_Concurrent(t *testing.T) {
+	circuitHook := NewCircuitHook(CircuitSettings{})
+	circuitHook.SetIdleTimeout(time.Millisecond)
+	client := core.Client{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				req := &TestServerRequest{Server: "server-" + strconv.Itoa((i*200+j)%500)}
+				if err := circuitHook.BeforeRequest(req, client); err != nil {
+					continue
+				}
+				var cErr error
+				if j%3 == 0 {
+					cErr = errors.New("some error happen")
+				}
+				circuitHook.AfterRequest(cErr, req, client)
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			circuitHook.Snapshots()
+			circuitHook.Breakers()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestCircuitHook_IdleTimeout(t *testing.T) {
+	circuitHook := NewCircuitHook(CircuitSettings{})
+	circuitHook.SetIdleTimeout(50 * time.Millisecond)
+	client := core.Client{}
+
+	for _, name := range []string{"a", "b", "c"} {
+		req := &TestServerRequest{Server: name}
+		circuitHook.BeforeRequest(req, client)
+		circuitHook.AfterRequest(nil, req, client)
+	}
+	cb, _ := circuitHook.Breaker("b")
+	cb.ForceOpen()
+	if circuitHook.cb.len() != 3 {
+		t.Fatal("breakers", circuitHook.cb.len())
+	}
+
+	// 请求过程中断路器被清理，请求结束时依然记录在原来的断路器上
+	inflight := &TestServerRequest{Server: "a"}
+	circuitHook.BeforeRequest(inflight, client)
+
+	time.Sleep(100 * time.Millisecond)
+	req := &TestServerRequest{Server: "d"}
+	circuitHook.BeforeRequest(req, client)
+	if _, ok := circuitHook.Breaker("a"); ok {
+		t.Fatal("idle breaker should be evicted")
+	}
+	if _, ok := circuitHook.Breaker("b"); !ok {
+		t.Fatal("forced breaker should not be evicted")
+	}
+	if circuitHook.cb.len() != 2 {
+		t.Fatal("breakers", circuitHook.Snapshots())
+	}
+
+	circuitHook.AfterRequest(errors.New("some error happen"), inflight, client)
+	if _, ok := circuitHook.Breaker("a"); ok {
+		t.Fatal("AfterRequest should not recreate breaker")
+	}
+
+	circuitHook.RemoveBreaker("b")
+	if _, ok := circuitHook.Breaker("b"); ok {
+		t.Fatal("RemoveBreaker")
+	}
+}
+
+func TestCircuitHook_SweepStateChange(t *testing.T) {
+	var circuitHook *CircuitHook
+	changed := make(chan State, 10)
+	circuitHook = NewCircuitHook(CircuitSettings{
+		Timeout:     10 * time.Millisecond,
+		ReadyToTrip: func(counts Counts) bool { return counts.ConsecutiveFailures > 0 },
+		// 回调中访问注册表
+		OnStateChange: func(name string, from State, to State) {
+			circuitHook.Breaker(name)
+			changed <- to
+		},
+	})
+	circuitHook.SetIdleTimeout(20 * time.Millisecond)
+	client := core.Client{}
+
+	req := &TestServerRequest{Server: "a"}
+	circuitHook.BeforeRequest(req, client)
+	circuitHook.AfterRequest(nil, req, client)
+	cb, _ := circuitHook.Breaker("a")
+	generation, _ := cb.beforeRequest()
+	cb.afterRequest(generation, false)
+	if to := <-changed; to != StateOpen {
+		t.Fatal("should trip", to)
+	}
+
+	// 清理时检查状态，Open超时转变为HalfOpen并调用OnStateChange
+	time.Sleep(30 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		req := &TestServerRequest{Server: "b"}
+		circuitHook.BeforeRequest(req, client)
+		circuitHook.AfterRequest(nil, req, client)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sweep should not hold the lock while calling OnStateChange")
+	}
+	if to := <-changed; to != StateHalfOpen {
+		t.Fatal("state", to)
+	}
+}
@@ -167,10 +167,10 @@ func TestNewCircuitHook(t *testing.T) {
 
 	// 过多的请求
 	for i := 0; i < 10; i++ {
-		// ErrTooManyRequests
+		// 请求对象不能并发使用
 		go func() {
-			_, err = c.DoRequest(req)
-			//fmt.Println(err)
+			// ErrTooManyRequests
+			c.DoRequest(&TestRequest{RequestURL: "http://127.0.0.1:6101/"})
 		}()
 		time.Sleep(time.Millisecond * 100)
 	}
@@ -0,0 +1,92 @@
+package hook
+
+import "time"
+
+const defaultWindowBuckets = 10
+
+// 滑动窗口中的一个时间桶
+type windowBucket struct {
+	// 桶的开始时间（UnixNano），用于判断桶是否过期
+	start     int64
+	requests  uint32
+	failures  uint32
+	slowCalls uint32
+}
+
+// 滑动时间窗口
+//   将窗口时间平均分成若干个桶，按时间轮转使用，
+//   统计最近一个窗口时间内的请求数、失败数以及慢请求数。
+//   非并发安全，由断路器加锁保护
+type slidingWindow struct {
+	bucketSize int64
+	buckets    []windowBucket
+}
+
+func newSlidingWindow(size time.Duration, buckets int) *slidingWindow {
+	if buckets <= 0 {
+		buckets = defaultWindowBuckets
+	}
+	bucketSize := int64(size) / int64(buckets)
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	return &slidingWindow{
+		bucketSize: bucketSize,
+		buckets:    make([]windowBucket, buckets),
+	}
+}
+
+// 记录一次请求结果
+func (w *slidingWindow) add(now time.Time, failure, slow bool) {
+	start := now.UnixNano() / w.bucketSize * w.bucketSize
+	b := &w.buckets[(start/w.bucketSize)%int64(len(w.buckets))]
+	if b.start != start {
+		*b = windowBucket{start: start}
+	}
+	b.requests++
+	if failure {
+		b.failures++
+	}
+	if slow {
+		b.slowCalls++
+	}
+}
+
+// 统计窗口内的数据，过期的桶不计算在内
+func (w *slidingWindow) totals(now time.Time) (requests, failures, slowCalls uint32) {
+	current := now.UnixNano() / w.bucketSize * w.bucketSize
+	oldest := current - w.bucketSize*int64(len(w.buckets)-1)
+	for _, b := range w.buckets {
+		if b.start < oldest || b.start > current {
+			continue
+		}
+		requests += b.requests
+		failures += b.failures
+		slowCalls += b.slowCalls
+	}
+	return
+}
+
+func (w *slidingWindow) clear() {
+	for i := range w.buckets {
+		w.buckets[i] = windowBucket{}
+	}
+}
+
+// 滑动窗口模式下默认的ReadyToTrip
+// 窗口内请求数达到最小请求数之后，失败率或者慢请求率达到阈值则进入Open状态
+func windowReadyToTrip(st CircuitSettings) func(counts Counts) bool {
+	failureRate := st.FailureRateThreshold
+	if failureRate <= 0 && st.SlowCallRateThreshold <= 0 {
+		failureRate = defaultFailureRateThreshold
+	}
+	return func(counts Counts) bool {
+		if counts.WindowRequests == 0 || counts.WindowRequests < st.MinRequests {
+			return false
+		}
+		if failureRate > 0 && counts.FailureRate() >= failureRate {
+			return true
+		}
+		return st.SlowCallRateThreshold > 0 && counts.SlowCallRate() >= st.SlowCallRateThreshold
+	}
+}
@@ -0,0 +1,100 @@
+package hook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	w := newSlidingWindow(time.Second, 10)
+	now := time.Unix(100, 0)
+	w.add(now, true, false)
+	w.add(now.Add(500*time.Millisecond), false, true)
+	if requests, failures, slow := w.totals(now.Add(500 * time.Millisecond)); requests != 2 || failures != 1 || slow != 1 {
+		t.Fatal("totals", requests, failures, slow)
+	}
+	// 第一个桶滑出窗口
+	if requests, failures, _ := w.totals(now.Add(time.Second)); requests != 1 || failures != 0 {
+		t.Fatal("slide", requests, failures)
+	}
+	// 同一个位置的桶被新的时间复用
+	w.add(now.Add(2*time.Second), true, false)
+	if requests, failures, _ := w.totals(now.Add(2 * time.Second)); requests != 1 || failures != 1 {
+		t.Fatal("reuse", requests, failures)
+	}
+	w.clear()
+	if requests, _, _ := w.totals(now.Add(2 * time.Second)); requests != 0 {
+		t.Fatal("clear", requests)
+	}
+}
+
+func TestCircuitBreaker_FailureRate(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitSettings{
+		Name:                 "test",
+		Window:               time.Minute,
+		MinRequests:          10,
+		FailureRateThreshold: 0.5,
+	})
+	excute := func(success bool) {
+		generation, err := breaker.beforeRequest()
+		if err != nil {
+			t.Fatal("beforeRequest", err)
+		}
+		breaker.afterRequest(generation, success)
+	}
+
+	// 请求数不足，即使全部失败也不会进入Open状态
+	for i := 0; i < 9; i++ {
+		excute(false)
+	}
+	if breaker.State() != StateClosed {
+		t.Fatal("MinRequests", breaker.Counts())
+	}
+	excute(true)
+	counts := breaker.Counts()
+	if counts.WindowRequests != 10 || counts.WindowFailures != 9 || counts.FailureRate() != 0.9 {
+		t.Fatal("Counts", counts)
+	}
+	if breaker.State() != StateClosed {
+		t.Fatal("success should not trip", counts)
+	}
+	excute(false)
+	if breaker.State() != StateOpen {
+		t.Fatal("FailureRate", breaker.Counts())
+	}
+	if breaker.Counts().WindowRequests != 0 {
+		t.Fatal("window should be cleared", breaker.Counts())
+	}
+}
+
+func TestCircuitBreaker_SlowCallRate(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitSettings{
+		Name:                  "test",
+		Window:                time.Minute,
+		MinRequests:           4,
+		SlowCallThreshold:     100 * time.Millisecond,
+		SlowCallRateThreshold: 0.5,
+	})
+	excute := func(long time.Duration) {
+		generation, err := breaker.beforeRequest()
+		if err != nil {
+			t.Fatal("beforeRequest", err)
+		}
+		breaker.afterRequestLong(generation, true, long)
+	}
+
+	excute(10 * time.Millisecond)
+	excute(10 * time.Millisecond)
+	excute(time.Second)
+	counts := breaker.Counts()
+	if counts.WindowSlowCalls != 1 || counts.WindowFailures != 1 || counts.TotalFailures != 1 {
+		t.Fatal("slow call should count as failure", counts)
+	}
+	if breaker.State() != StateClosed {
+		t.Fatal("MinRequests", counts)
+	}
+	excute(time.Second)
+	if breaker.State() != StateOpen {
+		t.Fatal("SlowCallRate", breaker.Counts())
+	}
+}
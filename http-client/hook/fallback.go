@@ -0,0 +1,122 @@
+package hook
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+// 静态降级响应
+// example:
+//
+//	circuitHook.SetFallback(StaticFallback(http.StatusOK, nil, []byte(`{"list":[]}`)))
+func StaticFallback(statusCode int, header http.Header, body []byte) core.FallbackFunc {
+	return func(err error, req core.Request) (*core.Response, bool) {
+		return core.NewResponse(statusCode, cloneHeader(header), body), true
+	}
+}
+
+// 最近一次成功响应降级
+//   作为钩子记录每一个GET/HEAD请求最近一次成功（2xx）的响应，
+//   请求失败时通过Lookup()以此响应降级。
+//
+// example:
+//
+//	lastGood := NewLastGoodFallback(10 * time.Minute)
+//	circuitHook.SetFallback(lastGood.Lookup) // 或者 client.SetFallback(lastGood.Lookup)
+//	client.AppendHook(lastGood, circuitHook)
+type LastGoodFallback struct {
+	// 响应最长保存时间，为零时不过期
+	maxAge time.Duration
+
+	mutex     sync.RWMutex
+	responses map[string]*lastGoodResponse
+}
+
+type lastGoodResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	saveTime   time.Time
+}
+
+func (l *LastGoodFallback) BeforeRequest(req core.Request, client core.Client) error {
+	return nil
+}
+
+func (l *LastGoodFallback) AfterRequest(cErr error, req core.Request, client core.Client) {
+	resp := req.Response()
+	if nil != cErr || nil == resp || nil == resp.Response || resp.IsFallback() || resp.IsStream() {
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return
+	}
+	httpReq := req.RawRequest()
+	if nil == httpReq || (httpReq.Method != http.MethodGet && httpReq.Method != http.MethodHead && httpReq.Method != "") {
+		return
+	}
+	body, err := resp.Bytes()
+	if nil != err {
+		return
+	}
+	// Bytes()已经解码
+	header := cloneHeader(resp.Header)
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.responses[requestKey(httpReq)] = &lastGoodResponse{
+		statusCode: resp.StatusCode,
+		header:     header,
+		body:       body,
+		saveTime:   time.Now(),
+	}
+}
+
+// 查找最近一次成功的响应
+// 可以作为core.FallbackFunc使用
+func (l *LastGoodFallback) Lookup(err error, req core.Request) (*core.Response, bool) {
+	httpReq := req.RawRequest()
+	if nil == httpReq {
+		// 请求被钩子拒绝，还没有构建
+		var buildErr error
+		if httpReq, buildErr = req.HttpRequest(); nil != buildErr {
+			return nil, false
+		}
+	}
+	l.mutex.RLock()
+	saved, ok := l.responses[requestKey(httpReq)]
+	l.mutex.RUnlock()
+	if !ok || (l.maxAge > 0 && time.Since(saved.saveTime) > l.maxAge) {
+		return nil, false
+	}
+	return core.NewResponse(saved.statusCode, cloneHeader(saved.header), saved.body), true
+}
+
+// 新建最近一次成功响应降级
+// @params maxAge 响应最长保存时间，为零时不过期
+func NewLastGoodFallback(maxAge time.Duration) *LastGoodFallback {
+	return &LastGoodFallback{
+		maxAge:    maxAge,
+		responses: make(map[string]*lastGoodResponse),
+	}
+}
+
+// 请求标识：方法+URL
+func requestKey(httpReq *http.Request) string {
+	method := httpReq.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	return method + " " + httpReq.URL.String()
+}
+
+func cloneHeader(header http.Header) http.Header {
+	if nil == header {
+		return make(http.Header)
+	}
+	return header.Clone()
+}
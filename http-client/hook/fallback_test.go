@@ -0,0 +1,78 @@
+package hook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+func TestCircuitHook_Fallback(t *testing.T) {
+	circuitHook := NewCircuitHook(CircuitSettings{})
+	circuitHook.SetFallback(StaticFallback(http.StatusOK, http.Header{"X-Fallback": {"static"}}, []byte("[]")))
+	metrics := NewMetricsHook("", nil)
+	c := core.NewClient("test", nil).AppendHook(metrics, circuitHook).SetMaxBadRetryCount(1)
+
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "test"}
+	// 请求失败，但断路器没有打开，不降级
+	if _, err := c.DoRequest(req); err == nil {
+		t.Fatal("error should not fallback")
+	}
+
+	cb, _ := circuitHook.Breaker("test")
+	cb.ForceOpen()
+	resp, err := c.DoRequest(req)
+	if nil != err || !resp.IsFallback() || resp.ToString() != "[]" || resp.Header.Get("X-Fallback") != "static" {
+		t.Fatal("Fallback", err)
+	}
+	if counts := cb.Counts(); counts.Requests != 0 {
+		t.Fatal("rejected request should not be counted", counts)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `http_client_request_fallbacks_total{server="test",method="unknown"} 1`) {
+		t.Fatal("metrics", rec.Body.String())
+	}
+}
+
+func TestLastGoodFallback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Name":"cbping"}`))
+	}))
+
+	lastGood := NewLastGoodFallback(time.Minute)
+	c := core.NewClient("test", nil).AppendHook(lastGood).SetMaxBadRetryCount(1)
+	c.SetFallback(lastGood.Lookup)
+	req := &TestRequest{RequestURL: ts.URL + "/a"}
+	if _, err := c.DoRequest(req); err != nil {
+		t.Fatal("DoRequest", err)
+	}
+	ts.Close()
+
+	resp, err := c.DoRequest(req)
+	if nil != err || !resp.IsFallback() || resp.ToString() != `{"Name":"cbping"}` ||
+		resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatal("LastGoodFallback", err)
+	}
+	// 降级响应不会被记录
+	resp, err = c.DoRequest(req)
+	if nil != err || !resp.IsFallback() {
+		t.Fatal("LastGoodFallback again", err)
+	}
+
+	if _, err = c.DoRequest(&TestRequest{RequestURL: ts.URL + "/b"}); err == nil {
+		t.Fatal("unknown request should not fallback")
+	}
+
+	expired := NewLastGoodFallback(time.Nanosecond)
+	expired.responses = lastGood.responses
+	time.Sleep(time.Millisecond)
+	if _, ok := expired.Lookup(nil, req); ok {
+		t.Fatal("expired response should not fallback")
+	}
+}
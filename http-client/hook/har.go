@@ -0,0 +1,473 @@
+package hook
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+const (
+	HARHookKey = "HARHook"
+
+	harVersion     = "1.2"
+	harCreatorName = "go-toolkit/http-client"
+
+	// 默认记录的body最大长度
+	defaultHARBodySize = 64 * 1024
+	// 默认最多保存的记录数
+	defaultHARMaxEntries = 1000
+)
+
+// HAR（HTTP Archive 1.2）格式，见 http://www.softwareishard.com/blog/har-12-spec/
+// 以下划线开头的字段为自定义字段
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// 一次尝试的请求以及响应
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	// 请求服务名
+	Server string `json:"_server,omitempty"`
+	// 第几次尝试，从1开始
+	Attempt int `json:"_attempt,omitempty"`
+	// 请求失败的错误信息，此时没有响应
+	Error string `json:"_error,omitempty"`
+	// 是否为对冲请求，以及对冲时是否为采用的请求
+	Hedge bool `json:"_hedge,omitempty"`
+	Won   bool `json:"_won,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+	// body超过最大长度，只记录了一部分
+	Truncated bool `json:"_truncated,omitempty"`
+}
+
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	// body超过最大长度，只记录了一部分
+	Truncated bool `json:"_truncated,omitempty"`
+}
+
+// 时间（毫秒），不知道的为-1
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HAR录制钩子配置
+//
+// ServerNames 只录制这些服务的请求。如果为空，录制所有请求
+//
+// RedactHeaders 需要脱敏的头部（包括Cookie）。如果为nil，默认为DefaultRedactHeaders
+//
+// RedactQuery 需要脱敏的查询参数，如 access_token
+//
+// MaxBodySize 记录的请求以及响应body最大长度。如果为零，默认为64KB；如果为负数，不记录body
+//
+// MaxEntries 最多保存的记录数，超过时丢弃最早的记录。如果为零，默认为1000
+type HARSettings struct {
+	ServerNames   []string
+	RedactHeaders []string
+	RedactQuery   []string
+	MaxBodySize   int
+	MaxEntries    int
+}
+
+// HAR录制钩子
+//   录制每一次尝试（包括重试）的请求以及响应：头部、body（不超过MaxBodySize）、时间以及错误，
+//   可以保存为HAR 1.2格式的JSON文件，在浏览器开发者工具中打开。用于调试。
+//   记录响应body时会先读取前MaxBodySize字节，读取的内容仍然可以通过响应读取；流式响应（见core.IsStream()）不记录body。
+//   钩子直接提供的响应（如缓存命中、降级响应）没有发送请求，不会录制。
+type HARHook struct {
+	settings HARSettings
+	servers  map[string]bool
+	redact   map[string]bool
+	query    map[string]bool
+
+	mutex   sync.Mutex
+	entries []HAREntry
+}
+
+// 一次请求的录制数据
+type harData struct {
+	mutex sync.Mutex
+	// 发送的请求对应的记录，对冲时响应可能不按发送顺序返回
+	requests map[*http.Request]*HAREntry
+	ended    bool
+}
+
+func (hh *HARHook) BeforeRequest(req core.Request, client core.Client) error {
+	if len(hh.servers) > 0 && !hh.servers[req.ServerName()] {
+		req.SetHookData(HARHookKey, nil)
+		return nil
+	}
+	req.SetHookData(HARHookKey, &harData{requests: make(map[*http.Request]*HAREntry)})
+	return nil
+}
+
+func (hh *HARHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+	data := harDataOf(req)
+	if nil == data {
+		return
+	}
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	if data.ended {
+		return
+	}
+	data.ended = true
+
+	// 按发送的请求对应尝试记录，
+	// 没有对应记录的（发送之前被其它钩子中断）没有发送，不保存
+	attempts := req.Attempts()
+	entries := make([]HAREntry, 0, len(attempts))
+	for _, a := range attempts {
+		entry, ok := data.requests[a.Request]
+		if !ok {
+			continue
+		}
+		entry.StartedDateTime = a.Start.Format(time.RFC3339Nano)
+		entry.Time = milliseconds(a.LongTime)
+		entry.Timings = HARTimings{Send: 0, Wait: entry.Time, Receive: 0}
+		entry.Attempt = a.Index
+		entry.Hedge, entry.Won = a.Hedge, a.Won
+		if nil != a.Err {
+			entry.Error = a.Err.Error()
+		}
+		entries = append(entries, *entry)
+	}
+	if 0 == len(entries) {
+		return
+	}
+
+	hh.mutex.Lock()
+	defer hh.mutex.Unlock()
+	hh.entries = append(hh.entries, entries...)
+	if over := len(hh.entries) - hh.settings.MaxEntries; over > 0 {
+		hh.entries = append([]HAREntry(nil), hh.entries[over:]...)
+	}
+}
+
+func (hh *HARHook) BeforeSend(httpReq *http.Request, req core.Request) error {
+	data := harDataOf(req)
+	if nil == data {
+		return nil
+	}
+	entry := &HAREntry{
+		Server:   req.ServerName(),
+		Request:  hh.request(httpReq),
+		Response: HARResponse{Cookies: []HARCookie{}, Headers: []HARNameValue{}, HeadersSize: -1, BodySize: -1},
+	}
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	if !data.ended {
+		data.requests[httpReq] = entry
+	}
+	return nil
+}
+
+func (hh *HARHook) AfterReceive(httpResp *http.Response, req core.Request) error {
+	data := harDataOf(req)
+	if nil == data {
+		return nil
+	}
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	if data.ended {
+		return nil
+	}
+	if entry, ok := data.requests[httpResp.Request]; ok {
+		entry.Response = hh.response(httpResp, req)
+	}
+	return nil
+}
+
+// 录制的记录
+func (hh *HARHook) Entries() []HAREntry {
+	hh.mutex.Lock()
+	defer hh.mutex.Unlock()
+	return append([]HAREntry(nil), hh.entries...)
+}
+
+// 清除录制的记录
+func (hh *HARHook) Reset() {
+	hh.mutex.Lock()
+	defer hh.mutex.Unlock()
+	hh.entries = nil
+}
+
+// 返回HAR
+func (hh *HARHook) HAR() *HAR {
+	entries := hh.Entries()
+	if nil == entries {
+		entries = []HAREntry{}
+	}
+	return &HAR{Log: HARLog{
+		Version: harVersion,
+		Creator: HARCreator{Name: harCreatorName, Version: core.Version},
+		Entries: entries,
+	}}
+}
+
+// 以JSON格式输出HAR
+func (hh *HARHook) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(hh.HAR(), "", "  ")
+	if nil != err {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// 保存HAR文件
+func (hh *HARHook) WriteFile(filename string) error {
+	f, err := os.Create(filename)
+	if nil != err {
+		return err
+	}
+	if _, err = hh.WriteTo(f); nil != err {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (hh *HARHook) request(httpReq *http.Request) HARRequest {
+	method := httpReq.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	u := *httpReq.URL
+	u.User = nil
+	query := u.Query()
+	for k := range query {
+		if hh.query[k] {
+			for i := range query[k] {
+				query[k][i] = redactedValue
+			}
+		}
+	}
+	if len(hh.query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	r := HARRequest{
+		Method:      method,
+		URL:         u.String(),
+		HTTPVersion: httpVersion(httpReq.Proto),
+		Cookies:     hh.cookies(httpReq.Cookies(), "Cookie"),
+		Headers:     hh.headers(httpReq.Header),
+		QueryString: nameValues(query),
+		HeadersSize: -1,
+		BodySize:    httpReq.ContentLength,
+	}
+	if nil != httpReq.Body && http.NoBody != httpReq.Body && hh.settings.MaxBodySize >= 0 {
+		var body []byte
+		var truncated bool
+		if nil != httpReq.GetBody {
+			// 复制一份body，不影响发送
+			if rc, err := httpReq.GetBody(); nil == err {
+				_, body, truncated = core.PeekBody(rc, hh.settings.MaxBodySize)
+				rc.Close()
+			}
+		} else {
+			httpReq.Body, body, truncated = core.PeekBody(httpReq.Body, hh.settings.MaxBodySize)
+		}
+		postData := &HARPostData{MimeType: httpReq.Header.Get("Content-Type"), Truncated: truncated}
+		postData.Text, postData.Encoding = harText(body)
+		r.PostData = postData
+	}
+	return r
+}
+
+func (hh *HARHook) response(httpResp *http.Response, req core.Request) HARResponse {
+	r := HARResponse{
+		Status:      httpResp.StatusCode,
+		StatusText:  http.StatusText(httpResp.StatusCode),
+		HTTPVersion: httpVersion(httpResp.Proto),
+		Cookies:     hh.cookies(httpResp.Cookies(), "Set-Cookie"),
+		Headers:     hh.headers(httpResp.Header),
+		RedirectURL: httpResp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    httpResp.ContentLength,
+		Content: HARContent{
+			Size:     httpResp.ContentLength,
+			MimeType: httpResp.Header.Get("Content-Type"),
+		},
+	}
+	// 流式响应不读取body
+	if hh.settings.MaxBodySize >= 0 && !core.IsStream(req, httpResp) {
+		var body []byte
+		httpResp.Body, body, r.Content.Truncated = core.PeekBody(httpResp.Body, hh.settings.MaxBodySize)
+		r.Content.Text, r.Content.Encoding = harText(body)
+		if r.Content.Size < 0 && !r.Content.Truncated {
+			r.Content.Size = int64(len(body))
+		}
+	}
+	return r
+}
+
+// 头部按名称排序，需要脱敏的头部替换为[REDACTED]
+func (hh *HARHook) headers(header http.Header) []HARNameValue {
+	nvs := make([]HARNameValue, 0, len(header))
+	for k, v := range header {
+		for _, value := range v {
+			if hh.redact[http.CanonicalHeaderKey(k)] {
+				value = redactedValue
+			}
+			nvs = append(nvs, HARNameValue{Name: k, Value: value})
+		}
+	}
+	sort.SliceStable(nvs, func(i, j int) bool { return nvs[i].Name < nvs[j].Name })
+	return nvs
+}
+
+func (hh *HARHook) cookies(cookies []*http.Cookie, header string) []HARCookie {
+	hc := make([]HARCookie, 0, len(cookies))
+	for _, c := range cookies {
+		value := c.Value
+		if hh.redact[header] {
+			value = redactedValue
+		}
+		hc = append(hc, HARCookie{Name: c.Name, Value: value})
+	}
+	return hc
+}
+
+// 新建HAR录制钩子
+func NewHARHook(settings HARSettings) *HARHook {
+	if settings.MaxBodySize == 0 {
+		settings.MaxBodySize = defaultHARBodySize
+	}
+	if settings.MaxEntries <= 0 {
+		settings.MaxEntries = defaultHARMaxEntries
+	}
+	if nil == settings.RedactHeaders {
+		settings.RedactHeaders = DefaultRedactHeaders
+	}
+	hh := &HARHook{
+		settings: settings,
+		servers:  make(map[string]bool, len(settings.ServerNames)),
+		redact:   make(map[string]bool, len(settings.RedactHeaders)),
+		query:    make(map[string]bool, len(settings.RedactQuery)),
+	}
+	for _, name := range settings.ServerNames {
+		hh.servers[name] = true
+	}
+	for _, k := range settings.RedactHeaders {
+		hh.redact[http.CanonicalHeaderKey(k)] = true
+	}
+	for _, k := range settings.RedactQuery {
+		hh.query[k] = true
+	}
+	return hh
+}
+
+func harDataOf(req core.Request) *harData {
+	data, ok := req.HookData(HARHookKey)
+	if !ok {
+		return nil
+	}
+	hd, _ := data.(*harData)
+	return hd
+}
+
+// 文本body直接记录，否则以base64编码
+func harText(body []byte) (text, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func nameValues(values url.Values) []HARNameValue {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	nvs := make([]HARNameValue, 0, len(values))
+	for _, k := range keys {
+		for _, v := range values[k] {
+			nvs = append(nvs, HARNameValue{Name: k, Value: v})
+		}
+	}
+	return nvs
+}
+
+func httpVersion(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+	return proto
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
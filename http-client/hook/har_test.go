@@ -0,0 +1,223 @@
+package hook
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+func TestHARHook(t *testing.T) {
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"name":"cbping"}` {
+			t.Error("request body should not be consumed", string(body))
+		}
+		count++
+		if count == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1,"padding":"` + strings.Repeat("a", 100) + `"}`))
+	}))
+	defer server.Close()
+
+	har := NewHARHook(HARSettings{RedactQuery: []string{"b"}, MaxBodySize: 50})
+	client := core.NewClient("test", nil).AppendHook(
+		har,
+		NewHeaderHook(http.Header{"Authorization": {"Bearer secret"}}, true),
+	)
+	client.SetRetryPolicy(&core.BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond, RetryNonIdempotent: true})
+
+	req := &TestPostRequest{RequestURL: server.URL + "/users", Body: `{"name":"cbping"}`}
+	resp, err := client.DoRequest(req)
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	// 录制之后仍然可以读取完整的body
+	if !strings.HasSuffix(resp.ToString(), `"}`) || len(resp.ToString()) != 121 {
+		t.Fatal("body should be readable after recording", resp.ToString())
+	}
+
+	entries := har.Entries()
+	if len(entries) != 2 {
+		t.Fatal("each attempt should be recorded", len(entries))
+	}
+	if entries[0].Attempt != 1 || entries[0].Response.Status != 503 || entries[1].Attempt != 2 || entries[1].Response.Status != 200 {
+		t.Fatal("attempts", entries[0].Attempt, entries[0].Response.Status, entries[1].Attempt, entries[1].Response.Status)
+	}
+	entry := entries[1]
+	if entry.Request.Method != "POST" || !strings.Contains(entry.Request.URL, "a=1&b=%5BREDACTED%5D") || entry.Server != req.ServerName() {
+		t.Fatal("request", entry.Request.Method, entry.Request.URL)
+	}
+	if nil == entry.Request.PostData || entry.Request.PostData.Text != `{"name":"cbping"}` {
+		t.Fatal("postData", entry.Request.PostData)
+	}
+	for _, h := range entry.Request.Headers {
+		if h.Name == "Authorization" && h.Value != "[REDACTED]" {
+			t.Fatal("Authorization should be redacted", h.Value)
+		}
+	}
+	if len(entry.Response.Cookies) != 1 || entry.Response.Cookies[0].Value != "[REDACTED]" {
+		t.Fatal("cookies should be redacted", entry.Response.Cookies)
+	}
+	content := entry.Response.Content
+	if content.Size != 121 || len(content.Text) != 50 || !content.Truncated || content.MimeType != "application/json" {
+		t.Fatal("content", content)
+	}
+	if entry.StartedDateTime == "" || entry.Time <= 0 {
+		t.Fatal("timings", entry.StartedDateTime, entry.Time)
+	}
+
+	// 请求失败也录制
+	server.Close()
+	har.Reset()
+	client.DoRequest(&TestRequest{RequestURL: server.URL})
+	entries = har.Entries()
+	if len(entries) != 2 || entries[1].Error == "" || entries[1].Response.Status != 0 {
+		t.Fatal("failed attempts", entries)
+	}
+
+	// 保存为HAR文件
+	filename := filepath.Join(t.TempDir(), "test.har")
+	if err := har.WriteFile(filename); nil != err {
+		t.Fatal("WriteFile", err)
+	}
+	data, _ := ioutil.ReadFile(filename)
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); nil != err {
+		t.Fatal("Unmarshal", err)
+	}
+	log := doc["log"].(map[string]interface{})
+	if log["version"] != "1.2" || len(log["entries"].([]interface{})) != 2 {
+		t.Fatal("har", string(data))
+	}
+}
+
+func TestHARHook_ServerNames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0xff, 0xfe, 0x00})
+	}))
+	defer server.Close()
+
+	har := NewHARHook(HARSettings{ServerNames: []string{"other"}})
+	client := core.NewClient("test", nil).AppendHook(har)
+	client.DoRequest(&TestRequest{RequestURL: server.URL})
+	if len(har.Entries()) != 0 {
+		t.Fatal("other servers should not be recorded")
+	}
+
+	har = NewHARHook(HARSettings{ServerNames: []string{(&TestRequest{}).ServerName()}})
+	client = core.NewClient("test", nil).AppendHook(har)
+	client.DoRequest(&TestRequest{RequestURL: server.URL})
+	entries := har.Entries()
+	if len(entries) != 1 || entries[0].Response.Content.Encoding != "base64" || entries[0].Response.Content.Text != "//4A" {
+		t.Fatal("binary body should be base64 encoded", entries)
+	}
+
+	var buf bytes.Buffer
+	if _, err := har.WriteTo(&buf); nil != err || !strings.Contains(buf.String(), `"creator"`) {
+		t.Fatal("WriteTo", err)
+	}
+}
+
+func TestHARHook_Gzip(t *testing.T) {
+	body := `{"name":"cbping"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte(body))
+		gw.Close()
+	}))
+	defer server.Close()
+
+	har := NewHARHook(HARSettings{})
+	client := core.NewClient("test", nil).AppendHook(har)
+	resp, err := client.DoRequest(&TestRequest{RequestURL: server.URL})
+	if nil != err || resp.ToString() != body {
+		t.Fatal("DoRequest", err)
+	}
+	entries := har.Entries()
+	if len(entries) != 1 {
+		t.Fatal("entries", len(entries))
+	}
+	content := entries[0].Response.Content
+	if content.Text != body || content.Encoding != "" || content.Size != int64(len(body)) || content.Truncated {
+		t.Fatal("content should be decoded", content)
+	}
+}
+
+func TestHARHook_Hedge(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第一个请求在对冲请求发出之后、返回之前失败
+		if atomic.AddInt32(&count, 1) == 1 {
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("hedge"))
+	}))
+	defer server.Close()
+
+	har := NewHARHook(HARSettings{MaxBodySize: -1})
+	client := core.NewClient("test", nil).AppendHook(har).
+		SetHedgePolicy(&core.HedgePolicy{Delay: 20 * time.Millisecond, MaxPercent: 1})
+	req := &TestRequest{RequestURL: server.URL}
+	req.SetHedge(true)
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.ToString() != "hedge" {
+		t.Fatal("DoRequest", err)
+	}
+	entries := har.Entries()
+	if len(entries) != 2 || entries[0].Hedge || entries[0].Won || !entries[1].Hedge || !entries[1].Won {
+		t.Fatal("hedge entries", entries)
+	}
+	// 响应按请求对应，而不是按返回顺序
+	if entries[0].Response.Status != http.StatusServiceUnavailable || entries[1].Response.Status != http.StatusOK {
+		t.Fatal("responses", entries[0].Response.Status, entries[0].Error, entries[1].Response.Status)
+	}
+}
+
+func TestHARHook_HedgeNotSent(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	har := NewHARHook(HARSettings{MaxBodySize: -1})
+	client, req := newHedgeNotSentClient(server, har)
+	if resp, err := client.DoRequest(req); nil != err || resp.ToString() != "ok" {
+		t.Fatal("DoRequest", err)
+	}
+	// 没有发送的对冲请求不录制，也不影响之后的尝试对应的记录
+	entries := har.Entries()
+	if len(entries) != 2 {
+		t.Fatal("entries", len(entries))
+	}
+	if entries[0].Attempt != 1 || entries[0].Response.Status != http.StatusServiceUnavailable || entries[0].Hedge {
+		t.Fatal("first attempt", entries[0].Attempt, entries[0].Response.Status)
+	}
+	if entries[1].Attempt != 2 || entries[1].Response.Status != http.StatusOK || "" != entries[1].Error || entries[1].Hedge {
+		t.Fatal("second attempt", entries[1].Attempt, entries[1].Response.Status, entries[1].Error)
+	}
+}
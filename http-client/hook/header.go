@@ -0,0 +1,47 @@
+package hook
+
+import (
+	"net/http"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+// 头部钩子
+//   每一次发送请求之前添加固定的头部，如 X-App-Id、Accept 等
+type HeaderHook struct {
+	header http.Header
+	// 是否覆盖请求中已经存在的头部
+	override bool
+}
+
+func (hh *HeaderHook) BeforeRequest(req core.Request, client core.Client) error {
+	return nil
+}
+
+func (hh *HeaderHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+}
+
+func (hh *HeaderHook) BeforeSend(httpReq *http.Request, req core.Request) error {
+	for k, v := range hh.header {
+		if !hh.override && len(httpReq.Header[k]) > 0 {
+			continue
+		}
+		httpReq.Header[k] = append([]string(nil), v...)
+	}
+	return nil
+}
+
+func (hh *HeaderHook) AfterReceive(httpResp *http.Response, req core.Request) error {
+	return nil
+}
+
+// 新建头部钩子
+// @params header   需要添加的头部
+// @params override 是否覆盖请求中已经存在的头部，否则只添加请求中没有的头部
+func NewHeaderHook(header http.Header, override bool) *HeaderHook {
+	hh := &HeaderHook{header: make(http.Header), override: override}
+	for k, v := range header {
+		hh.header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+	return hh
+}
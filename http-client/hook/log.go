@@ -7,19 +7,27 @@ import (
 )
 
 const (
-	SlowReqRecord  = "SlowReqRecord"
-	ReqRecord      = "ReqRecord"
+	SlowReqRecord = "SlowReqRecord"
+	ReqRecord     = "ReqRecord"
+	// 请求失败、没有响应或者5xx响应
 	ErrorReqRecord = "ErrorReqRecord"
+	// 4xx响应
+	ClientErrorReqRecord = "ClientErrorReqRecord"
 
 	// 默认慢请求时间
 	defaultSlowReqLong = 5 * time.Second
+
+	// 流式响应不记录body
+	streamBody = "[stream]"
 )
 
+// 日志钩子
+//   以文本记录请求，需要键值字段的日志时请使用StructuredLogHook
 type LogHook struct {
 	// 超过SlowReqLong时间长度的请求，
 	// 将记录为慢请求。
 	// 如果为负数，代表不记录
-	// 默认为2秒
+	// 默认为5秒
 	slowReqLong time.Duration
 
 	// 函数参数
@@ -32,23 +40,43 @@ func (log *LogHook) BeforeRequest(req core.Request, client core.Client) error {
 }
 
 func (log *LogHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+	if nil == log.record {
+		return
+	}
+	resp := req.Response()
 	if nil != cErr {
-		if nil != log.record {
-			log.record(ErrorReqRecord, fmt.Sprintf("query:: %s error:: %v ", req.String(), cErr))
-		}
-	} else {
-		if nil != log.record {
-			resp := req.Response()
-			reqInfo := fmt.Sprintf(" http query:: %s status:%d \n response:%s \n ts:(%v) \n",
-				req.String(),
-				resp.StatusCode,
-				resp.ToString(),
-				req.ReqLongTime())
-			if log.slowReqLong > 0 && req.ReqLongTime() >= log.slowReqLong {
-				log.record(SlowReqRecord, reqInfo)
-			}
-			log.record(ReqRecord, reqInfo)
+		msg := fmt.Sprintf("query:: %s error:: %v ", req.String(), cErr)
+		if nil != resp && resp.IsFallback() && nil != resp.Response {
+			msg += fmt.Sprintf("fallback:: status:%d ", resp.StatusCode)
 		}
+		log.record(ErrorReqRecord, msg)
+		return
+	}
+	// 没有错误也可能没有响应，如钩子返回了空的响应
+	if nil == resp || nil == resp.Response {
+		log.record(ErrorReqRecord, fmt.Sprintf("query:: %s error:: no response ts:(%v) ", req.String(), req.ReqLongTime()))
+		return
+	}
+	// 流式响应不读取body
+	body := streamBody
+	if !resp.IsStream() {
+		body = resp.ToString()
+	}
+	reqInfo := fmt.Sprintf(" http query:: %s status:%d \n response:%s \n ts:(%v) \n",
+		req.String(),
+		resp.StatusCode,
+		body,
+		req.ReqLongTime())
+	if log.slowReqLong > 0 && req.ReqLongTime() >= log.slowReqLong {
+		log.record(SlowReqRecord, reqInfo)
+	}
+	switch {
+	case resp.StatusCode >= 500:
+		log.record(ErrorReqRecord, reqInfo)
+	case resp.StatusCode >= 400:
+		log.record(ClientErrorReqRecord, reqInfo)
+	default:
+		log.record(ReqRecord, reqInfo)
 	}
 }
 
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"time"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"github.com/BPing/go-toolkit/http-client/core"
 )
@@ -58,3 +60,112 @@ func TestClient_SetSlowReqLong(t *testing.T) {
 		t.Fatal("SetSlowReqLong fail")
 	}
 }
+
+func TestLogHook_StatusTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notfound":
+			w.WriteHeader(http.StatusNotFound)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	var tags []string
+	var msgs []string
+	record := func(tag, msg string) {
+		tags = append(tags, tag)
+		msgs = append(msgs, msg)
+	}
+	c := core.NewClient("test", nil).AppendHook(NewLogHook(10*time.Millisecond, record))
+
+	cases := []struct {
+		path string
+		tags string
+	}{
+		{"/ok", ReqRecord},
+		{"/notfound", ClientErrorReqRecord},
+		{"/error", ErrorReqRecord},
+		{"/slow", SlowReqRecord + "," + ReqRecord},
+	}
+	for _, cs := range cases {
+		tags, msgs = nil, nil
+		resp, err := c.DoRequest(&TestRequest{RequestURL: server.URL + cs.path})
+		if nil != err {
+			t.Fatal("DoRequest", cs.path, err)
+		}
+		if strings.Join(tags, ",") != cs.tags || !strings.Contains(msgs[0], "response:body") {
+			t.Fatal("tags", cs.path, tags, msgs)
+		}
+		// 记录之后仍然可以读取body
+		if resp.ToString() != "body" {
+			t.Fatal("body", cs.path)
+		}
+	}
+
+	// 请求失败
+	tags, msgs = nil, nil
+	server.Close()
+	c.DoRequest(&TestRequest{RequestURL: server.URL})
+	if strings.Join(tags, ",") != ErrorReqRecord || !strings.Contains(msgs[0], "error::") {
+		t.Fatal("failed request", tags, msgs)
+	}
+}
+
+func TestLogHook_NilResponse(t *testing.T) {
+	var tags []string
+	var msgs []string
+	record := func(tag, msg string) {
+		tags = append(tags, tag)
+		msgs = append(msgs, msg)
+	}
+	// 钩子返回没有*http.Response的响应，不应该panic
+	empty := core.RoundTripHookFunc(func(req core.Request, client core.Client, next core.RoundTripFunc) (*core.Response, error) {
+		return &core.Response{}, nil
+	})
+	c := core.NewClient("test", nil).AppendHook(NewLogHook(time.Duration(0), record), empty)
+	_, err := c.DoRequest(&TestRequest{RequestURL: "http://127.0.0.1/"})
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	if strings.Join(tags, ",") != ErrorReqRecord || !strings.Contains(msgs[0], "no response") {
+		t.Fatal("nil response", tags, msgs)
+	}
+}
+
+func TestLogHook_Stream(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: a\n\n"))
+		w.(http.Flusher).Flush()
+		// 流式响应没有结束，读取整个body会阻塞
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	var msgs []string
+	record := func(tag, msg string) {
+		msgs = append(msgs, msg)
+	}
+	c := core.NewClient("test", nil).AppendHook(NewLogHook(time.Duration(0), record))
+	resp, err := c.DoRequest(&TestRequest{RequestURL: ts.URL})
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	defer resp.Body.Close()
+	if len(msgs) != 1 || !strings.Contains(msgs[0], streamBody) {
+		t.Fatal("stream body should not be logged", msgs)
+	}
+	if event, err := resp.Events().Next(); nil != err || event.Data != "a" {
+		t.Fatal("stream should still be readable", event, err)
+	}
+}
@@ -0,0 +1,318 @@
+package hook
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+const (
+	// 默认指标名前缀
+	defaultMetricsNamespace = "http_client"
+
+	// Prometheus 文本格式
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	// 请求没有发送时的请求方法
+	unknownMethod = "unknown"
+)
+
+// 默认请求时间直方图分桶（秒）
+var DefaultMetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 指标标签
+type metricsKey struct {
+	server string
+	method string
+}
+
+// 请求数标签
+type metricsCodeKey struct {
+	metricsKey
+	code string
+}
+
+// 错误数标签
+type metricsErrorKey struct {
+	metricsKey
+	reason string
+}
+
+// 直方图
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// 统计钩子
+//   按 ServerName() 以及请求方法统计请求数、错误数、重试次数、状态码分类以及请求时间分布，
+//   请求没有发送（如被钩子拒绝）时请求方法为unknown，
+//   实现了http.Handler，以Prometheus文本格式输出指标。
+//
+// 指标（以默认前缀为例）：
+//   http_client_requests_total{server,method,code}        请求数，code为2xx、4xx、5xx等，请求失败时为error
+//   http_client_request_errors_total{server,method,reason} 失败请求数，reason为timeout或者error
+//   http_client_request_retries_total{server,method}       重试次数，即 ReqCount()-1
+//   http_client_request_fallbacks_total{server,method}     降级响应数
+//   http_client_request_duration_seconds{server,method}    请求时间分布，即 ReqLongTime()
+type MetricsHook struct {
+	namespace string
+	buckets   []float64
+
+	mutex     sync.Mutex
+	requests  map[metricsCodeKey]uint64
+	errors    map[metricsErrorKey]uint64
+	retries   map[metricsKey]uint64
+	fallbacks map[metricsKey]uint64
+	durations map[metricsKey]*histogram
+}
+
+func (m *MetricsHook) BeforeRequest(req core.Request, client core.Client) error {
+	return nil
+}
+
+func (m *MetricsHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+	key := metricsKey{server: req.ServerName(), method: requestMethod(req)}
+	code := "error"
+	if nil == cErr {
+		code = statusClass(req.Response())
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requests[metricsCodeKey{metricsKey: key, code: code}]++
+	if nil != cErr {
+		reason := "error"
+		if core.IsTimeout(cErr) {
+			reason = "timeout"
+		}
+		m.errors[metricsErrorKey{metricsKey: key, reason: reason}]++
+	}
+	if req.ReqCount() > 1 {
+		m.retries[key] += uint64(req.ReqCount() - 1)
+	}
+	if resp := req.Response(); nil != resp && resp.IsFallback() {
+		m.fallbacks[key]++
+	}
+	h, ok := m.durations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[key] = h
+	}
+	seconds := req.ReqLongTime().Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// 以Prometheus文本格式输出指标
+func (m *MetricsHook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	m.WriteTo(w)
+}
+
+// 指标的副本，写入时不持有锁，以免抓取较慢时阻塞请求
+type metricsSnapshot struct {
+	requests  map[metricsCodeKey]uint64
+	errors    map[metricsErrorKey]uint64
+	retries   map[metricsKey]uint64
+	fallbacks map[metricsKey]uint64
+	durations map[metricsKey]*histogram
+}
+
+func (m *MetricsHook) snapshot() *metricsSnapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := &metricsSnapshot{
+		requests:  make(map[metricsCodeKey]uint64, len(m.requests)),
+		errors:    make(map[metricsErrorKey]uint64, len(m.errors)),
+		retries:   make(map[metricsKey]uint64, len(m.retries)),
+		fallbacks: make(map[metricsKey]uint64, len(m.fallbacks)),
+		durations: make(map[metricsKey]*histogram, len(m.durations)),
+	}
+	for k, v := range m.requests {
+		s.requests[k] = v
+	}
+	for k, v := range m.errors {
+		s.errors[k] = v
+	}
+	for k, v := range m.retries {
+		s.retries[k] = v
+	}
+	for k, v := range m.fallbacks {
+		s.fallbacks[k] = v
+	}
+	for k, h := range m.durations {
+		s.durations[k] = &histogram{counts: append([]uint64(nil), h.counts...), sum: h.sum, count: h.count}
+	}
+	return s
+}
+
+// 以Prometheus文本格式写入指标
+func (m *MetricsHook) WriteTo(w io.Writer) (int64, error) {
+	s := m.snapshot()
+	buf := bufio.NewWriter(w)
+	bw := &countWriter{w: buf}
+
+	name := m.namespace + "_requests_total"
+	writeMetricHeader(bw, name, "counter", "Total number of outbound requests.")
+	requestKeys := make([]metricsCodeKey, 0, len(s.requests))
+	for k := range s.requests {
+		requestKeys = append(requestKeys, k)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		if requestKeys[i].metricsKey != requestKeys[j].metricsKey {
+			return requestKeys[i].metricsKey.less(requestKeys[j].metricsKey)
+		}
+		return requestKeys[i].code < requestKeys[j].code
+	})
+	for _, k := range requestKeys {
+		fmt.Fprintf(bw, "%s{%s,code=%s} %d\n", name, k.labels(), quoteLabel(k.code), s.requests[k])
+	}
+
+	name = m.namespace + "_request_errors_total"
+	writeMetricHeader(bw, name, "counter", "Total number of failed outbound requests.")
+	errorKeys := make([]metricsErrorKey, 0, len(s.errors))
+	for k := range s.errors {
+		errorKeys = append(errorKeys, k)
+	}
+	sort.Slice(errorKeys, func(i, j int) bool {
+		if errorKeys[i].metricsKey != errorKeys[j].metricsKey {
+			return errorKeys[i].metricsKey.less(errorKeys[j].metricsKey)
+		}
+		return errorKeys[i].reason < errorKeys[j].reason
+	})
+	for _, k := range errorKeys {
+		fmt.Fprintf(bw, "%s{%s,reason=%s} %d\n", name, k.labels(), quoteLabel(k.reason), s.errors[k])
+	}
+
+	name = m.namespace + "_request_retries_total"
+	writeMetricHeader(bw, name, "counter", "Total number of outbound request retries.")
+	for _, k := range sortedMetricsKeys(s.retries) {
+		fmt.Fprintf(bw, "%s{%s} %d\n", name, k.labels(), s.retries[k])
+	}
+
+	name = m.namespace + "_request_fallbacks_total"
+	writeMetricHeader(bw, name, "counter", "Total number of outbound requests answered by a fallback response.")
+	for _, k := range sortedMetricsKeys(s.fallbacks) {
+		fmt.Fprintf(bw, "%s{%s} %d\n", name, k.labels(), s.fallbacks[k])
+	}
+
+	name = m.namespace + "_request_duration_seconds"
+	writeMetricHeader(bw, name, "histogram", "Outbound request latency in seconds, including retries.")
+	durationKeys := make([]metricsKey, 0, len(s.durations))
+	for k := range s.durations {
+		durationKeys = append(durationKeys, k)
+	}
+	sort.Slice(durationKeys, func(i, j int) bool { return durationKeys[i].less(durationKeys[j]) })
+	for _, k := range durationKeys {
+		h := s.durations[k]
+		for i, bound := range m.buckets {
+			fmt.Fprintf(bw, "%s_bucket{%s,le=%s} %d\n", name, k.labels(),
+				quoteLabel(strconv.FormatFloat(bound, 'g', -1, 64)), h.counts[i])
+		}
+		fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, k.labels(), h.count)
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", name, k.labels(), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", name, k.labels(), h.count)
+	}
+	err := buf.Flush()
+	return bw.n, err
+}
+
+// 新建统计钩子
+// @params namespace 指标名前缀，为空时默认为 http_client
+// @params buckets   请求时间直方图分桶（秒，升序），为nil时采用DefaultMetricsBuckets
+func NewMetricsHook(namespace string, buckets []float64) *MetricsHook {
+	if namespace == "" {
+		namespace = defaultMetricsNamespace
+	}
+	if nil == buckets {
+		buckets = DefaultMetricsBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &MetricsHook{
+		namespace: namespace,
+		buckets:   buckets,
+		requests:  make(map[metricsCodeKey]uint64),
+		errors:    make(map[metricsErrorKey]uint64),
+		retries:   make(map[metricsKey]uint64),
+		fallbacks: make(map[metricsKey]uint64),
+		durations: make(map[metricsKey]*histogram),
+	}
+}
+
+func (k metricsKey) less(o metricsKey) bool {
+	if k.server != o.server {
+		return k.server < o.server
+	}
+	return k.method < o.method
+}
+
+func (k metricsKey) labels() string {
+	return "server=" + quoteLabel(k.server) + ",method=" + quoteLabel(k.method)
+}
+
+func sortedMetricsKeys(m map[metricsKey]uint64) []metricsKey {
+	keys := make([]metricsKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	return keys
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 转义标签值
+func quoteLabel(val string) string {
+	return `"` + labelReplacer.Replace(val) + `"`
+}
+
+// 返回请求方法，请求没有发送（如被钩子拒绝）时为unknownMethod
+func requestMethod(req core.Request) string {
+	if httpReq := req.RawRequest(); nil != httpReq {
+		if httpReq.Method == "" {
+			return http.MethodGet
+		}
+		return httpReq.Method
+	}
+	return unknownMethod
+}
+
+// 返回状态码分类，如2xx
+func statusClass(resp *core.Response) string {
+	if nil == resp || nil == resp.Response {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode/100) + "xx"
+}
+
+// 记录写入字节数
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,121 @@
+package hook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+type TestServerRequest struct {
+	TestRequest
+	Server string
+}
+
+func (b *TestServerRequest) ServerName() string {
+	return b.Server
+}
+
+func TestMetricsHook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	metrics := NewMetricsHook("", []float64{0.5, 0.1})
+	c := core.NewClient("test", nil).AppendHook(metrics)
+	c.SetRetryPolicy(&core.BackoffRetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, RetryStatusCodes: []int{500}})
+
+	for i := 0; i < 2; i++ {
+		c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "api"})
+	}
+	c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL + "/fail"}, Server: "api"})
+	c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "down\"svc"})
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatal("Content-Type", rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE http_client_requests_total counter",
+		`http_client_requests_total{server="api",method="GET",code="2xx"} 2`,
+		`http_client_requests_total{server="api",method="GET",code="5xx"} 1`,
+		`http_client_requests_total{server="down\"svc",method="GET",code="error"} 1`,
+		`http_client_request_errors_total{server="down\"svc",method="GET",reason="error"} 1`,
+		`http_client_request_retries_total{server="api",method="GET"} 2`,
+		`http_client_request_retries_total{server="down\"svc",method="GET"} 2`,
+		"# TYPE http_client_request_duration_seconds histogram",
+		`http_client_request_duration_seconds_bucket{server="api",method="GET",le="+Inf"} 3`,
+		`http_client_request_duration_seconds_count{server="api",method="GET"} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatal("metrics missing:", line, "\n", body)
+		}
+	}
+	if strings.Index(body, `le="0.1"`) > strings.Index(body, `le="0.5"`) {
+		t.Fatal("buckets should be sorted", body)
+	}
+}
+
+// 写入时阻塞，直到unblock关闭
+type blockingWriter struct {
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case w.started <- struct{}{}:
+	default:
+	}
+	<-w.unblock
+	return len(p), nil
+}
+
+func TestMetricsHook_SlowScrape(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	metrics := NewMetricsHook("", nil)
+	c := core.NewClient("test", nil).AppendHook(metrics)
+	w := &blockingWriter{started: make(chan struct{}, 1), unblock: make(chan struct{})}
+	go metrics.WriteTo(w)
+	defer close(w.unblock)
+	<-w.started
+
+	// 抓取阻塞时请求不受影响
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "api"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if nil != err {
+			t.Fatal("DoRequest", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AfterRequest should not be blocked by a slow scrape")
+	}
+}
+
+func TestMetricsHook_Rejected(t *testing.T) {
+	metrics := NewMetricsHook("", nil)
+	c := core.NewClient("test", nil).AppendHook(metrics, rejectHook{})
+	if _, err := c.DoRequest(&TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/"}, Server: "api"}); err != errRejected {
+		t.Fatal("request should be rejected", err)
+	}
+	// 没有发送的请求，请求方法为unknown
+	var buf strings.Builder
+	metrics.WriteTo(&buf)
+	if line := `http_client_requests_total{server="api",method="unknown",code="error"} 1`; !strings.Contains(buf.String(), line+"\n") {
+		t.Fatal("metrics missing:", line, "\n", buf.String())
+	}
+}
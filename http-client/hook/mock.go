@@ -0,0 +1,129 @@
+package hook
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+var ErrNoMock = errors.New("no mock response")
+
+// 模拟响应函数，返回错误时模拟请求失败
+type MockResponder func(httpReq *http.Request) (*core.Response, error)
+
+type mockRule struct {
+	method    string
+	url       string
+	responder MockResponder
+}
+
+// 模拟钩子
+//   按请求方法以及URL返回模拟响应，不发送请求，用于测试以及联调。
+//   URL支持path.Match的通配符：包含协议（如 http://host/v1/*）时匹配完整URL（不包括查询参数），
+//   否则只匹配路径（如 /v1/users/*）。按添加顺序匹配第一个。
+//   没有匹配的模拟响应时，默认返回ErrNoMock；设置了passThrough时发送请求。
+type MockHook struct {
+	passThrough bool
+
+	mutex sync.RWMutex
+	rules []*mockRule
+	calls map[string]int
+}
+
+func (m *MockHook) BeforeRequest(req core.Request, client core.Client) error {
+	return nil
+}
+
+func (m *MockHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+}
+
+func (m *MockHook) RoundTrip(req core.Request, client core.Client, next core.RoundTripFunc) (*core.Response, error) {
+	httpReq, err := req.HttpRequest()
+	if nil != err {
+		return nil, err
+	}
+	rule := m.match(httpReq)
+	if nil == rule {
+		if m.passThrough {
+			return next(req)
+		}
+		return nil, ErrNoMock
+	}
+	m.mutex.Lock()
+	m.calls[mockCallKey(rule.method, rule.url)]++
+	m.mutex.Unlock()
+	return rule.responder(httpReq)
+}
+
+// 添加模拟响应
+// @params method 请求方法，为空时匹配所有方法
+// @params url    URL或者路径
+func (m *MockHook) Mock(method, url string, statusCode int, header http.Header, body []byte) *MockHook {
+	return m.MockFunc(method, url, func(httpReq *http.Request) (*core.Response, error) {
+		return core.NewResponse(statusCode, cloneHeader(header), append([]byte(nil), body...)), nil
+	})
+}
+
+// 添加模拟响应函数
+func (m *MockHook) MockFunc(method, url string, responder MockResponder) *MockHook {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rules = append(m.rules, &mockRule{method: strings.ToUpper(method), url: url, responder: responder})
+	return m
+}
+
+// 模拟响应被调用的次数
+func (m *MockHook) Calls(method, url string) int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.calls[mockCallKey(strings.ToUpper(method), url)]
+}
+
+// 清除所有模拟响应以及调用次数
+func (m *MockHook) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rules = nil
+	m.calls = make(map[string]int)
+}
+
+func (m *MockHook) match(httpReq *http.Request) *mockRule {
+	method := httpReq.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for _, rule := range m.rules {
+		if rule.method != "" && rule.method != method {
+			continue
+		}
+		target := httpReq.URL.Path
+		if strings.Contains(rule.url, "://") {
+			u := *httpReq.URL
+			u.RawQuery, u.Fragment = "", ""
+			target = u.String()
+		}
+		if ok, _ := path.Match(rule.url, target); ok {
+			return rule
+		}
+	}
+	return nil
+}
+
+// 新建模拟钩子
+// @params passThrough 没有匹配的模拟响应时是否发送请求
+func NewMockHook(passThrough bool) *MockHook {
+	return &MockHook{
+		passThrough: passThrough,
+		calls:       make(map[string]int),
+	}
+}
+
+func mockCallKey(method, url string) string {
+	return method + " " + url
+}
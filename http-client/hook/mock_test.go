@@ -0,0 +1,85 @@
+package hook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+func TestMockHook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("network"))
+	}))
+	defer ts.Close()
+
+	mock := NewMockHook(false).
+		Mock("GET", "/users/*", http.StatusOK, http.Header{"Content-Type": {"application/json"}}, []byte(`{"Name":"cbping"}`)).
+		MockFunc("", ts.URL+"/down", func(httpReq *http.Request) (*core.Response, error) {
+			return nil, errors.New("connection refused")
+		})
+	client := core.NewClient("test", nil).AppendHook(mock)
+
+	for i := 0; i < 2; i++ {
+		req := &TestRequest{RequestURL: ts.URL + "/users/1?q=1"}
+		resp, err := client.DoRequest(req)
+		if nil != err || resp.ToString() != `{"Name":"cbping"}` || resp.Header.Get("Content-Type") != "application/json" {
+			t.Fatal("Mock", err)
+		}
+		if resp.Source() != core.SourceHook || req.ReqCount() != 0 {
+			t.Fatal("mock response should not be sent", resp.Source(), req.ReqCount())
+		}
+	}
+	if mock.Calls("GET", "/users/*") != 2 {
+		t.Fatal("Calls", mock.Calls("GET", "/users/*"))
+	}
+	if _, err := client.DoRequest(&TestRequest{RequestURL: ts.URL + "/down"}); nil == err || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatal("MockFunc error", err)
+	}
+	if _, err := client.DoRequest(&TestRequest{RequestURL: ts.URL + "/other"}); nil == err || !errors.Is(err, ErrNoMock) {
+		t.Fatal("ErrNoMock", err)
+	}
+
+	passThrough := NewMockHook(true)
+	client = core.NewClient("test", nil).AppendHook(passThrough)
+	if resp, err := client.DoRequest(&TestRequest{RequestURL: ts.URL + "/other"}); nil != err || resp.ToString() != "network" || resp.Source() != core.SourceNetwork {
+		t.Fatal("pass through", err)
+	}
+	mock.Reset()
+	if mock.Calls("GET", "/users/*") != 0 {
+		t.Fatal("Reset")
+	}
+}
+
+// 钩子直接提供响应时，日志、断路器以及统计钩子仍然正常工作
+func TestMockHook_Compat(t *testing.T) {
+	var tags []string
+	logHook := NewLogHook(time.Second, func(tag, msg string) {
+		tags = append(tags, tag)
+	})
+	circuitHook := NewCircuitHook(CircuitSettings{})
+	metrics := NewMetricsHook("", nil)
+	mock := NewMockHook(false).Mock("", "/*", http.StatusOK, nil, []byte("mock"))
+	client := core.NewClient("test", nil).AppendHook(logHook, metrics, circuitHook, mock)
+
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: "http://127.0.0.1:1/mock"}, Server: "mock"}
+	if resp, err := client.DoRequest(req); nil != err || resp.ToString() != "mock" {
+		t.Fatal("DoRequest", err)
+	}
+	if len(tags) != 1 || tags[0] != ReqRecord {
+		t.Fatal("LogHook", tags)
+	}
+	cb, _ := circuitHook.Breaker("mock")
+	if counts := cb.Counts(); counts.Requests != 0 || counts.TotalSuccesses != 0 {
+		t.Fatal("short-circuited request should not be counted", counts)
+	}
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `http_client_requests_total{server="mock",method="unknown",code="2xx"} 1`) {
+		t.Fatal("metrics", rec.Body.String())
+	}
+}
@@ -0,0 +1,175 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+var ErrInvalidTokenType = errors.New("oauth2: token type is not bearer")
+
+// 默认的令牌请求超时时间
+const defaultOAuth2Timeout = 10 * time.Second
+
+// OAuth2客户端凭证模式（client_credentials）配置
+//
+// TokenURL 令牌地址
+//
+// ClientID、ClientSecret 客户端凭证
+//
+// Scopes 申请的权限范围
+//
+// EndpointParams 令牌请求的其它参数，如 audience
+//
+// AuthInParams 客户端凭证是否放在表单参数中。默认使用HTTP Basic认证
+//
+// RefreshBefore 令牌过期之前多长时间开始在后台刷新。如果为零，默认为10秒
+//
+// Client 请求令牌的客户端。如果为nil，新建一个（超时时间为10秒）。
+//        请不要在此客户端上添加OAuth2钩子，以免循环获取令牌
+type OAuth2Config struct {
+	TokenURL       string
+	ClientID       string
+	ClientSecret   string
+	Scopes         []string
+	EndpointParams url.Values
+	AuthInParams   bool
+	RefreshBefore  time.Duration
+	Client         *core.Client
+}
+
+// 令牌地址返回的错误
+type OAuth2Error struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuth2Error) Error() string {
+	msg := "oauth2: cannot fetch token: status:" + strconv.Itoa(e.StatusCode)
+	if e.Code != "" {
+		msg += " error:" + e.Code
+	}
+	if e.Description != "" {
+		msg += " description:" + e.Description
+	}
+	return msg
+}
+
+// 令牌响应
+type oauth2Token struct {
+	AccessToken string      `json:"access_token"`
+	TokenType   string      `json:"token_type"`
+	ExpiresIn   json.Number `json:"expires_in"`
+}
+
+// 令牌请求
+type oauth2TokenRequest struct {
+	core.BaseRequest
+	config *OAuth2Config
+}
+
+func (r *oauth2TokenRequest) HttpRequest() (*http.Request, error) {
+	form := url.Values{}
+	for k, v := range r.config.EndpointParams {
+		form[k] = append([]string(nil), v...)
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(r.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(r.config.Scopes, " "))
+	}
+	if r.config.AuthInParams {
+		form.Set("client_id", r.config.ClientID)
+		form.Set("client_secret", r.config.ClientSecret)
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, r.config.TokenURL, strings.NewReader(form.Encode()))
+	if nil != err {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	if !r.config.AuthInParams {
+		httpReq.SetBasicAuth(url.QueryEscape(r.config.ClientID), url.QueryEscape(r.config.ClientSecret))
+	}
+	return httpReq, nil
+}
+
+func (r *oauth2TokenRequest) ServerName() string {
+	if u, err := url.Parse(r.config.TokenURL); nil == err {
+		return u.Host
+	}
+	return r.config.TokenURL
+}
+
+func (r *oauth2TokenRequest) String() string {
+	return "oauth2 token:" + r.config.TokenURL
+}
+
+// 新建OAuth2客户端凭证模式的令牌获取函数
+func NewOAuth2TokenSource(config OAuth2Config) TokenSource {
+	if nil == config.Client {
+		config.Client = core.NewClient("oauth2", nil).SetTimeOut(defaultOAuth2Timeout)
+	}
+	return func(ctx context.Context) (string, time.Time, error) {
+		resp, err := config.Client.DoRequestContext(ctx, &oauth2TokenRequest{config: &config})
+		if nil != err {
+			return "", time.Time{}, err
+		}
+		body, err := resp.Bytes()
+		if nil != err {
+			return "", time.Time{}, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			oauthErr := &OAuth2Error{StatusCode: resp.StatusCode}
+			json.Unmarshal(body, oauthErr)
+			return "", time.Time{}, oauthErr
+		}
+		var token oauth2Token
+		if err = json.Unmarshal(body, &token); nil != err {
+			return "", time.Time{}, err
+		}
+		if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+			return "", time.Time{}, ErrInvalidTokenType
+		}
+		var expiry time.Time
+		if seconds, err := token.ExpiresIn.Int64(); nil == err && seconds > 0 {
+			expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+		return token.AccessToken, expiry, nil
+	}
+}
+
+// OAuth2钩子
+//   以客户端凭证模式获取令牌，添加 Authorization: Bearer <token> 头部。
+//   令牌缓存到过期为止，过期之前在后台提前刷新，并发请求只会获取一次（见BearerTokenHook）。
+//   收到401响应时作废令牌，获取新的令牌之后重试一次。
+type OAuth2Hook struct {
+	*BearerTokenHook
+}
+
+func (oh *OAuth2Hook) RoundTrip(req core.Request, client core.Client, next core.RoundTripFunc) (*core.Response, error) {
+	resp, err := next(req)
+	if nil != err || nil == resp || nil == resp.Response || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// 令牌已经在AfterReceive中作废，重试时将获取新的令牌
+	if nil != resp.Body {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+	}
+	return next(req)
+}
+
+// 新建OAuth2钩子
+func NewOAuth2Hook(config OAuth2Config) *OAuth2Hook {
+	return &OAuth2Hook{NewBearerTokenHook(NewOAuth2TokenSource(config), config.RefreshBefore)}
+}
@@ -0,0 +1,145 @@
+package hook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+// 模拟的令牌服务
+type tokenServer struct {
+	*httptest.Server
+	issued    int32
+	expiresIn int
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	ts := &tokenServer{expiresIn: expiresIn}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"bad credentials"}`))
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" || r.FormValue("audience") != "api" {
+			t.Error("token request", r.Form)
+		}
+		// 模拟获取令牌比较慢
+		time.Sleep(10 * time.Millisecond)
+		n := atomic.AddInt32(&ts.issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token` + strconv.Itoa(int(n)) + `","token_type":"Bearer","expires_in":` + strconv.Itoa(ts.expiresIn) + `}`))
+	}))
+	return ts
+}
+
+func TestOAuth2Hook(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	defer tokens.Close()
+
+	// 只接受最新的令牌
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token"+strconv.Itoa(int(atomic.LoadInt32(&tokens.issued))) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer api.Close()
+
+	config := OAuth2Config{
+		TokenURL:       tokens.URL,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		Scopes:         []string{"read", "write"},
+		EndpointParams: map[string][]string{"audience": {"api"}},
+	}
+	oauth2 := NewOAuth2Hook(config)
+	client := core.NewClient("test", nil).AppendHook(oauth2).SetMaxBadRetryCount(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.DoRequest(&TestRequest{RequestURL: api.URL})
+			if nil != err || resp.ToString() != "ok" {
+				t.Error("DoRequest", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&tokens.issued) != 1 {
+		t.Fatal("concurrent requests should fetch token once", tokens.issued)
+	}
+
+	// 服务端作废令牌，401之后获取新令牌重试一次
+	atomic.AddInt32(&tokens.issued, 1)
+	req := &TestRequest{RequestURL: api.URL}
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.ToString() != "ok" {
+		t.Fatal("should retry with new token", err)
+	}
+	if req.ReqCount() != 2 || len(req.Attempts()) != 2 || req.Attempts()[0].StatusCode != http.StatusUnauthorized {
+		t.Fatal("ReqCount", req.ReqCount(), req.Attempts())
+	}
+	if req.RawRequest().Header.Get("Authorization") != "Bearer token3" {
+		t.Fatal("Authorization", req.RawRequest().Header)
+	}
+}
+
+func TestOAuth2Hook_ProactiveRefresh(t *testing.T) {
+	// 令牌5秒之后过期，提前10秒刷新
+	tokens := newTokenServer(t, 5)
+	defer tokens.Close()
+
+	source := NewOAuth2TokenSource(OAuth2Config{
+		TokenURL:       tokens.URL,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		Scopes:         []string{"read", "write"},
+		EndpointParams: map[string][]string{"audience": {"api"}},
+	})
+	bearer := NewBearerTokenHook(source, 10*time.Second)
+	first, err := bearer.Token(context.Background())
+	if nil != err || first != "token1" {
+		t.Fatal("Token", first, err)
+	}
+	// 即将过期的令牌仍然可用，同时后台只刷新一次
+	for i := 0; i < 5; i++ {
+		if token, err := bearer.Token(context.Background()); nil != err || token != "token1" {
+			t.Fatal("stale token should be used while refreshing", token, err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		token, _ := bearer.Token(context.Background())
+		if token == "token2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("token should be refreshed in background", token)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOAuth2TokenSource_Error(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	defer tokens.Close()
+
+	source := NewOAuth2TokenSource(OAuth2Config{TokenURL: tokens.URL, ClientID: "client", ClientSecret: "wrong"})
+	_, _, err := source(context.Background())
+	oauthErr, ok := err.(*OAuth2Error)
+	if !ok || oauthErr.StatusCode != http.StatusUnauthorized || oauthErr.Code != "invalid_client" {
+		t.Fatal("OAuth2Error", err)
+	}
+}
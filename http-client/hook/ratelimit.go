@@ -0,0 +1,361 @@
+package hook
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+const (
+	RateLimitHookKey = "RateLimitHook"
+
+	// 根据响应头部暂停请求的默认最长时间
+	defaultRateLimitMaxHeaderWait = time.Minute
+)
+
+// Rate 每秒允许的请求数。小于等于零时不限制
+//
+// Burst 令牌桶容量，即允许的突发请求数。如果小于等于零，默认为 Rate 向上取整（至少为1）
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// 限流规则
+//
+// ServerName 服务名，为空时匹配所有服务
+//
+// Method 请求方法，为空时匹配所有方法
+//
+// Path 请求路径，支持path.Match的通配符（如 /v1/orders/*），为空时匹配所有路径
+//
+// 每个服务各自拥有一个令牌桶，即使规则没有指定服务名。
+// 规则指定了Method或者Path时，将调用HttpRequest()构建请求以便匹配
+type RateLimitRule struct {
+	ServerName string
+	Method     string
+	Path       string
+	RateLimit
+}
+
+// Default 没有匹配规则时的限流设置
+//
+// Rules 限流规则，按顺序匹配第一个
+//
+// Wait 令牌不足时是否阻塞等待。默认不等待，直接返回ErrRateLimited
+//
+// MaxWait 阻塞等待的最长时间，需要等待更久时直接返回ErrRateLimited。
+//         为零时一直等待，直到请求的上下文结束
+//
+// AdaptHeaders 是否根据响应头部暂停请求：
+//              429、503响应的 Retry-After，
+//              以及 X-RateLimit-Remaining 为0时的 X-RateLimit-Reset（秒数或者Unix时间戳）
+//
+// MaxHeaderWait 根据响应头部暂停请求的最长时间。如果为零，默认为1分钟
+type RateLimitSettings struct {
+	Default       RateLimit
+	Rules         []RateLimitRule
+	Wait          bool
+	MaxWait       time.Duration
+	AdaptHeaders  bool
+	MaxHeaderWait time.Duration
+}
+
+// 限流钩子
+//   令牌桶算法，按 ServerName() 以及规则限制请求速率，适用于有QPS配额的第三方接口。
+//   每一次尝试（包括重试以及对冲）消耗一个令牌：
+//   第一次尝试的令牌在BeforeRequest中获取，令牌不足时拒绝请求；
+//   之后的尝试在BeforeSend中获取，令牌不足时终止请求并返回ErrRateLimited。
+//   设置了Wait时两者同样等待令牌（不超过MaxWait）。
+type RateLimitHook struct {
+	settings RateLimitSettings
+	// 规则是否需要匹配请求方法或者路径
+	matchRequest bool
+
+	mutex   sync.Mutex
+	buckets map[rateLimitKey]*tokenBucket
+}
+
+type rateLimitKey struct {
+	server string
+	// 规则下标，-1代表默认设置
+	rule int
+}
+
+func (rl *RateLimitHook) BeforeRequest(req core.Request, client core.Client) error {
+	// 清理上一次请求的数据
+	req.SetHookData(RateLimitHookKey, nil)
+	bucket, err := rl.getBucket(req)
+	if nil != err {
+		return err
+	}
+	if nil == bucket {
+		return nil
+	}
+	req.SetHookData(RateLimitHookKey, &rateLimitData{bucket: bucket})
+	return rl.take(req, bucket)
+}
+
+func (rl *RateLimitHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+	if !rl.settings.AdaptHeaders {
+		return
+	}
+	data := rateLimitDataOf(req)
+	if nil == data {
+		return
+	}
+	bucket := data.bucket
+	resp := req.Response()
+	if nil == resp || nil == resp.Response || resp.IsFallback() {
+		return
+	}
+	now := time.Now()
+	if wait, ok := headerWait(resp.Response, now); ok {
+		maxWait := rl.settings.MaxHeaderWait
+		if maxWait <= 0 {
+			maxWait = defaultRateLimitMaxHeaderWait
+		}
+		if wait > maxWait {
+			wait = maxWait
+		}
+		bucket.block(now.Add(wait))
+	}
+}
+
+// 第一次尝试使用BeforeRequest中获取的令牌，之后的尝试（重试、对冲）各自获取一个令牌
+func (rl *RateLimitHook) BeforeSend(httpReq *http.Request, req core.Request) error {
+	data := rateLimitDataOf(req)
+	if nil == data || atomic.AddInt32(&data.sends, 1) == 1 {
+		return nil
+	}
+	return rl.take(req, data.bucket)
+}
+
+func (rl *RateLimitHook) AfterReceive(httpResp *http.Response, req core.Request) error {
+	return nil
+}
+
+// 获取一个令牌，设置了Wait时等待（不超过MaxWait），请求的上下文结束时返回其错误
+func (rl *RateLimitHook) take(req core.Request, bucket *tokenBucket) error {
+	maxWait := time.Duration(0)
+	if rl.settings.Wait {
+		maxWait = rl.settings.MaxWait
+		if maxWait <= 0 {
+			maxWait = time.Duration(math.MaxInt64)
+		}
+	}
+	wait, ok := bucket.reserve(time.Now(), maxWait)
+	if !ok {
+		return ErrRateLimited
+	}
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		bucket.cancel()
+		return req.Context().Err()
+	}
+}
+
+// 一次请求的限流数据
+type rateLimitData struct {
+	bucket *tokenBucket
+	// 发送的次数，对冲时并发访问
+	sends int32
+}
+
+func rateLimitDataOf(req core.Request) *rateLimitData {
+	data, ok := req.HookData(RateLimitHookKey)
+	if !ok {
+		return nil
+	}
+	rlData, _ := data.(*rateLimitData)
+	return rlData
+}
+
+// 获取请求对应的令牌桶，不限流时返回nil
+func (rl *RateLimitHook) getBucket(req core.Request) (*tokenBucket, error) {
+	var httpReq *http.Request
+	if rl.matchRequest {
+		var err error
+		if httpReq, err = req.HttpRequest(); nil != err {
+			return nil, err
+		}
+	}
+	server := req.ServerName()
+	key := rateLimitKey{server: server, rule: -1}
+	limit := rl.settings.Default
+	for i, rule := range rl.settings.Rules {
+		if rule.match(server, httpReq) {
+			key.rule, limit = i, rule.RateLimit
+			break
+		}
+	}
+	if limit.Rate <= 0 && !rl.settings.AdaptHeaders {
+		return nil, nil
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(limit)
+		rl.buckets[key] = bucket
+	}
+	return bucket, nil
+}
+
+func (rule *RateLimitRule) match(server string, httpReq *http.Request) bool {
+	if rule.ServerName != "" && rule.ServerName != server {
+		return false
+	}
+	if nil == httpReq {
+		return rule.Method == "" && rule.Path == ""
+	}
+	if rule.Method != "" {
+		method := httpReq.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		if !strings.EqualFold(rule.Method, method) {
+			return false
+		}
+	}
+	if rule.Path != "" {
+		if ok, _ := path.Match(rule.Path, httpReq.URL.Path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func NewRateLimitHook(settings RateLimitSettings) *RateLimitHook {
+	rl := &RateLimitHook{
+		settings: settings,
+		buckets:  make(map[rateLimitKey]*tokenBucket),
+	}
+	rl.settings.Rules = append([]RateLimitRule(nil), settings.Rules...)
+	for _, rule := range rl.settings.Rules {
+		if rule.Method != "" || rule.Path != "" {
+			rl.matchRequest = true
+		}
+	}
+	return rl
+}
+
+// 令牌桶
+//   令牌可以透支，透支的令牌代表已经预定、正在等待的请求
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// 根据响应头部暂停请求，直到此时间
+	blockedUntil time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// 预定一个令牌
+// @params maxWait 最长等待时间，需要等待更久时不预定
+// @return wait    获得令牌之前需要等待的时间
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var wait time.Duration
+	if b.rate > 0 {
+		b.advance(now)
+		if b.tokens < 1 {
+			wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		}
+	}
+	if blocked := b.blockedUntil.Sub(now); blocked > wait {
+		wait = blocked
+	}
+	if wait > maxWait {
+		return 0, false
+	}
+	if b.rate > 0 {
+		b.tokens--
+	}
+	return wait, true
+}
+
+// 归还没有使用的令牌
+func (b *tokenBucket) cancel() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.rate > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+1)
+	}
+}
+
+// 暂停请求直到指定时间
+func (b *tokenBucket) block(until time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+// 补充令牌，调用时需持有锁
+func (b *tokenBucket) advance(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// 根据响应头部计算需要暂停的时间
+func headerWait(httpResp *http.Response, now time.Time) (time.Duration, bool) {
+	if httpResp.StatusCode == http.StatusTooManyRequests || httpResp.StatusCode == http.StatusServiceUnavailable {
+		if wait, ok := core.RetryAfter(httpResp, now); ok {
+			return wait, true
+		}
+	}
+	if httpResp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+	reset, err := strconv.ParseInt(httpResp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if nil != err || reset < 0 {
+		return 0, false
+	}
+	// 较大的数值视为Unix时间戳，否则为秒数
+	if reset > 1e9 {
+		wait := time.Unix(reset, 0).Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return time.Duration(reset) * time.Second, true
+}
@@ -0,0 +1,204 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+type TestMethodRequest struct {
+	TestServerRequest
+	Method string
+}
+
+func (t *TestMethodRequest) HttpRequest() (*http.Request, error) {
+	return http.NewRequest(t.Method, t.RequestURL, nil)
+}
+
+func newRateLimitServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := r.URL.Query().Get("status"); status != "" {
+			code, _ := strconv.Atoi(status)
+			w.WriteHeader(code)
+			return
+		}
+		if retryAfter := r.URL.Query().Get("retry_after"); retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if reset := r.URL.Query().Get("reset"); reset != "" {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", reset)
+		}
+	}))
+}
+
+func TestRateLimitHook_FailFast(t *testing.T) {
+	ts := newRateLimitServer()
+	defer ts.Close()
+
+	rl := NewRateLimitHook(RateLimitSettings{Default: RateLimit{Rate: 1, Burst: 2}})
+	client := core.NewClient("test", nil).AppendHook(rl).SetMaxBadRetryCount(1)
+	for i := 0; i < 2; i++ {
+		req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "api"}
+		if _, err := client.DoRequest(req); nil != err {
+			t.Fatal("DoRequest", i, err)
+		}
+	}
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "api"}
+	if _, err := client.DoRequest(req); err != ErrRateLimited {
+		t.Fatal("should be rate limited", err)
+	}
+	// 其它服务各自限流
+	other := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "other"}
+	if _, err := client.DoRequest(other); nil != err {
+		t.Fatal("other", err)
+	}
+}
+
+func TestRateLimitHook_Wait(t *testing.T) {
+	ts := newRateLimitServer()
+	defer ts.Close()
+
+	rl := NewRateLimitHook(RateLimitSettings{Default: RateLimit{Rate: 50, Burst: 1}, Wait: true})
+	client := core.NewClient("test", nil).AppendHook(rl).SetMaxBadRetryCount(1)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "api"}
+		if _, err := client.DoRequest(req); nil != err {
+			t.Fatal("DoRequest", i, err)
+		}
+	}
+	if long := time.Since(start); long < 35*time.Millisecond {
+		t.Fatal("requests should wait for tokens", long)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "api"}
+	if _, err := client.DoRequestContext(ctx, req); err != context.DeadlineExceeded {
+		t.Fatal("context timeout", err)
+	}
+
+	rl = NewRateLimitHook(RateLimitSettings{Default: RateLimit{Rate: 1, Burst: 1}, Wait: true, MaxWait: 10 * time.Millisecond})
+	client = core.NewClient("test", nil).AppendHook(rl).SetMaxBadRetryCount(1)
+	client.DoRequest(req)
+	if _, err := client.DoRequest(req); err != ErrRateLimited {
+		t.Fatal("wait longer than MaxWait", err)
+	}
+}
+
+func TestRateLimitHook_Retry(t *testing.T) {
+	ts := newRateLimitServer()
+	defer ts.Close()
+
+	// 每一次重试消耗一个令牌，令牌不足时终止重试
+	rl := NewRateLimitHook(RateLimitSettings{Default: RateLimit{Rate: 0.1, Burst: 2}})
+	client := core.NewClient("test", nil).AppendHook(rl).
+		SetRetryPolicy(&core.BackoffRetryPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL + "?status=503"}, Server: "api"}
+	if _, err := client.DoRequest(req); !errors.Is(err, ErrRateLimited) || req.ReqCount() != 2 {
+		t.Fatal("retry should take a token", err, req.ReqCount())
+	}
+	if _, err := client.DoRequest(req); err != ErrRateLimited || req.ReqCount() != 0 {
+		t.Fatal("should be rate limited", err, req.ReqCount())
+	}
+
+	// 等待令牌之后重试，返回最后一次的响应
+	rl = NewRateLimitHook(RateLimitSettings{Default: RateLimit{Rate: 20, Burst: 1}, Wait: true, MaxWait: time.Second})
+	client = core.NewClient("test", nil).AppendHook(rl).
+		SetRetryPolicy(&core.BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+	start := time.Now()
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.StatusCode != http.StatusServiceUnavailable || req.ReqCount() != 2 {
+		t.Fatal("retry should wait for a token", err, req.ReqCount())
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatal("retry should wait", elapsed)
+	}
+}
+
+func TestRateLimitHook_Rules(t *testing.T) {
+	ts := newRateLimitServer()
+	defer ts.Close()
+
+	rl := NewRateLimitHook(RateLimitSettings{
+		Rules: []RateLimitRule{
+			{ServerName: "api", Method: "POST", Path: "/orders/*", RateLimit: RateLimit{Rate: 0.1}},
+		},
+	})
+	client := core.NewClient("test", nil).AppendHook(rl).SetMaxBadRetryCount(1)
+	newReq := func(method, path string) *TestMethodRequest {
+		return &TestMethodRequest{
+			TestServerRequest: TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL + path}, Server: "api"},
+			Method:            method,
+		}
+	}
+	if _, err := client.DoRequest(newReq("POST", "/orders/1")); nil != err {
+		t.Fatal("first POST", err)
+	}
+	if _, err := client.DoRequest(newReq("POST", "/orders/2")); err != ErrRateLimited {
+		t.Fatal("second POST should be rate limited", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := client.DoRequest(newReq("GET", "/orders/1")); nil != err {
+			t.Fatal("GET should not be rate limited", err)
+		}
+		if _, err := client.DoRequest(newReq("POST", "/users")); nil != err {
+			t.Fatal("other path should not be rate limited", err)
+		}
+	}
+}
+
+func TestRateLimitHook_AdaptHeaders(t *testing.T) {
+	ts := newRateLimitServer()
+	defer ts.Close()
+
+	rl := NewRateLimitHook(RateLimitSettings{AdaptHeaders: true})
+	client := core.NewClient("test", nil).AppendHook(rl).SetMaxBadRetryCount(1)
+	req := &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL + "?retry_after=1"}, Server: "api"}
+	if resp, err := client.DoRequest(req); nil != err || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatal("DoRequest", err)
+	}
+	req = &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL}, Server: "api"}
+	if _, err := client.DoRequest(req); err != ErrRateLimited {
+		t.Fatal("Retry-After should pause requests", err)
+	}
+
+	req = &TestServerRequest{TestRequest: TestRequest{RequestURL: ts.URL + "?reset=1"}, Server: "github"}
+	if _, err := client.DoRequest(req); nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	if _, err := client.DoRequest(req); err != ErrRateLimited {
+		t.Fatal("X-RateLimit-Reset should pause requests", err)
+	}
+}
+
+func TestHeaderWait(t *testing.T) {
+	now := time.Now()
+	httpResp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	if _, ok := headerWait(httpResp, now); ok {
+		t.Fatal("no header")
+	}
+	httpResp.Header.Set("X-RateLimit-Remaining", "0")
+	httpResp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(30*time.Second).Unix(), 10))
+	if wait, ok := headerWait(httpResp, now); !ok || wait <= 28*time.Second || wait > 30*time.Second {
+		t.Fatal("X-RateLimit-Reset timestamp", wait)
+	}
+	httpResp.Header.Set("X-RateLimit-Remaining", "10")
+	if _, ok := headerWait(httpResp, now); ok {
+		t.Fatal("remaining requests")
+	}
+	httpResp.StatusCode = http.StatusServiceUnavailable
+	httpResp.Header.Set("Retry-After", now.Add(time.Minute).UTC().Format(http.TimeFormat))
+	if wait, ok := headerWait(httpResp, now); !ok || wait <= 58*time.Second {
+		t.Fatal("Retry-After date", wait)
+	}
+}
@@ -0,0 +1,269 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+const (
+	// 日志消息
+	structuredLogMessage = "http request"
+
+	// 默认记录的响应body最大长度
+	defaultLogBodySize = 1024
+
+	// 脱敏之后的头部值
+	redactedValue = "[REDACTED]"
+)
+
+// 默认脱敏的头部
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// 日志级别
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = -4
+	LogLevelInfo  LogLevel = 0
+	LogLevelWarn  LogLevel = 4
+	LogLevelError LogLevel = 8
+)
+
+func (l LogLevel) String() string {
+	switch {
+	case l >= LogLevelError:
+		return "ERROR"
+	case l >= LogLevelWarn:
+		return "WARN"
+	case l >= LogLevelInfo:
+		return "INFO"
+	}
+	return "DEBUG"
+}
+
+// 日志字段
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// 结构化日志接口
+//   go1.21及以上可以通过NewSlogLogger()使用log/slog
+type StructuredLogger interface {
+	Log(ctx context.Context, level LogLevel, msg string, fields ...LogField)
+}
+
+// 结构化日志钩子配置
+//
+// Logger 日志输出。如果为nil，不记录
+//
+// SlowReqLong 超过此时间的请求以WARN级别记录，并标记slow。如果为零，默认为5秒；如果为负数，不标记
+//
+// SampleRate 成功请求日志的采样率，取值0到1。如果为零，默认为1，即全部记录；如果为负数，不记录成功请求。
+//            失败、4xx、5xx以及慢请求总是记录
+//
+// LogHeaders 是否记录请求以及响应头部
+//
+// RedactHeaders 需要脱敏的头部。如果为nil，默认为DefaultRedactHeaders
+//
+// LogBody 是否记录响应body。只读取前MaxBodySize字节，读取的内容仍然可以通过响应读取；流式响应不记录
+//
+// MaxBodySize 记录的响应body最大长度。如果为零，默认为1024
+type StructuredLogSettings struct {
+	Logger        StructuredLogger
+	SlowReqLong   time.Duration
+	SampleRate    float64
+	LogHeaders    bool
+	RedactHeaders []string
+	LogBody       bool
+	MaxBodySize   int
+}
+
+// 结构化日志钩子
+//   请求结束时以键值字段记录一条日志：
+//   method、url、server、status、latency、attempts、bytes（响应Content-Length，未知时不记录）、
+//   source（非网络响应时）、error、slow、body_too_large（响应body超过最大长度时）、stream（流式响应时）、trace_id（同时使用跟踪钩子时），
+//   以及可选的 request_headers、response_headers、body、body_truncated。
+//   请求失败以及5xx为ERROR级别，4xx以及慢请求为WARN级别，其它为INFO级别。
+type StructuredLogHook struct {
+	settings StructuredLogSettings
+	redact   map[string]bool
+}
+
+func (sl *StructuredLogHook) BeforeRequest(req core.Request, client core.Client) error {
+	return nil
+}
+
+func (sl *StructuredLogHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+	if nil == sl.settings.Logger {
+		return
+	}
+	resp := req.Response()
+	hasResp := nil != resp && nil != resp.Response
+	slow := sl.settings.SlowReqLong > 0 && req.ReqLongTime() >= sl.settings.SlowReqLong
+
+	level := LogLevelInfo
+	switch {
+	case nil != cErr || (hasResp && resp.StatusCode >= 500):
+		level = LogLevelError
+	case (hasResp && resp.StatusCode >= 400) || slow:
+		level = LogLevelWarn
+	}
+	if level == LogLevelInfo && !sl.sample() {
+		return
+	}
+
+	fields := make([]LogField, 0, 16)
+	fields = append(fields, LogField{"method", requestMethod(req)})
+	if httpReq := req.RawRequest(); nil != httpReq {
+		fields = append(fields, LogField{"url", redactURL(httpReq)})
+	}
+	fields = append(fields, LogField{"server", req.ServerName()})
+	if hasResp {
+		fields = append(fields, LogField{"status", resp.StatusCode})
+	}
+	fields = append(fields,
+		LogField{"latency", req.ReqLongTime()},
+		LogField{"attempts", req.ReqCount()},
+	)
+	if hasResp && resp.ContentLength >= 0 {
+		fields = append(fields, LogField{"bytes", resp.ContentLength})
+	}
+	if nil != resp && resp.Source() != "" && resp.Source() != core.SourceNetwork {
+		fields = append(fields, LogField{"source", string(resp.Source())})
+	}
+	if nil != cErr {
+		fields = append(fields, LogField{"error", cErr.Error()})
+	}
+	if slow {
+		fields = append(fields, LogField{"slow", true})
+	}
+	if data := traceDataOf(req); nil != data {
+		data.mutex.Lock()
+		fields = append(fields, LogField{"trace_id", data.span.SpanContext.TraceID.String()})
+		data.mutex.Unlock()
+	}
+	if sl.settings.LogHeaders {
+		if httpReq := req.RawRequest(); nil != httpReq {
+			fields = append(fields, LogField{"request_headers", sl.headers(httpReq.Header)})
+		}
+		if hasResp {
+			fields = append(fields, LogField{"response_headers", sl.headers(resp.Header)})
+		}
+	}
+	if hasResp && resp.IsStream() {
+		fields = append(fields, LogField{"stream", true})
+	}
+	if sl.settings.LogBody && hasResp && !resp.IsStream() {
+		var body []byte
+		var truncated bool
+		resp.Body, body, truncated = core.PeekBody(resp.Body, sl.settings.MaxBodySize)
+		if utf8.Valid(body) {
+			fields = append(fields, LogField{"body", string(body)})
+		}
+		if truncated {
+			fields = append(fields, LogField{"body_truncated", true})
+		}
+	}
+	// 读取body时才能发现没有Content-Length的body超过最大长度
+	if errors.Is(cErr, core.ErrBodyTooLarge) || (nil != resp && resp.Truncated()) {
+		fields = append(fields, LogField{"body_too_large", true})
+	}
+	sl.settings.Logger.Log(req.Context(), level, structuredLogMessage, fields...)
+}
+
+func (sl *StructuredLogHook) sample() bool {
+	rate := sl.settings.SampleRate
+	if rate < 0 {
+		return false
+	}
+	return rate == 0 || rate >= 1 || rand.Float64() < rate
+}
+
+// 头部按名称合并为一个值，需要脱敏的头部替换为[REDACTED]
+func (sl *StructuredLogHook) headers(header http.Header) map[string]string {
+	m := make(map[string]string, len(header))
+	for k, v := range header {
+		if sl.redact[http.CanonicalHeaderKey(k)] {
+			m[k] = redactedValue
+			continue
+		}
+		m[k] = strings.Join(v, ", ")
+	}
+	return m
+}
+
+// 新建结构化日志钩子
+func NewStructuredLogHook(settings StructuredLogSettings) *StructuredLogHook {
+	if settings.SlowReqLong == 0 {
+		settings.SlowReqLong = defaultSlowReqLong
+	}
+	if settings.MaxBodySize <= 0 {
+		settings.MaxBodySize = defaultLogBodySize
+	}
+	if nil == settings.RedactHeaders {
+		settings.RedactHeaders = DefaultRedactHeaders
+	}
+	redact := make(map[string]bool, len(settings.RedactHeaders))
+	for _, k := range settings.RedactHeaders {
+		redact[http.CanonicalHeaderKey(k)] = true
+	}
+	return &StructuredLogHook{settings: settings, redact: redact}
+}
+
+// JSON日志，每一条日志一行
+type JSONLogger struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (jl *JSONLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	buf := bytes.NewBufferString(`{"time":`)
+	writeJSONValue(buf, time.Now().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(buf, msg)
+	for _, field := range fields {
+		buf.WriteByte(',')
+		writeJSONValue(buf, field.Key)
+		buf.WriteByte(':')
+		writeJSONValue(buf, field.Value)
+	}
+	buf.WriteString("}\n")
+
+	jl.mutex.Lock()
+	defer jl.mutex.Unlock()
+	jl.w.Write(buf.Bytes())
+}
+
+// 新建JSON日志
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+// 时间间隔以字符串（如1.5s）记录，错误记录其信息
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	switch value := v.(type) {
+	case time.Duration:
+		v = value.String()
+	case error:
+		v = value.Error()
+	}
+	b, err := json.Marshal(v)
+	if nil != err {
+		b, _ = json.Marshal(err.Error())
+	}
+	buf.Write(b)
+}
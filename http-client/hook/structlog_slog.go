@@ -0,0 +1,45 @@
+//go:build go1.21
+// +build go1.21
+
+package hook
+
+import (
+	"context"
+	"log/slog"
+)
+
+// log/slog日志
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+func (sl *SlogLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	if !sl.logger.Enabled(ctx, slog.Level(level)) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range fields {
+		if header, ok := field.Value.(map[string]string); ok {
+			group := make([]interface{}, 0, len(header))
+			for k, v := range header {
+				group = append(group, slog.String(k, v))
+			}
+			attrs = append(attrs, slog.Group(field.Key, group...))
+			continue
+		}
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+	sl.logger.LogAttrs(ctx, slog.Level(level), msg, attrs...)
+}
+
+// 新建log/slog日志
+// @params logger 为nil时使用slog.Default()
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	if nil == logger {
+		logger = slog.Default()
+	}
+	return &SlogLogger{logger: logger}
+}
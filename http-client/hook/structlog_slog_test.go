@@ -0,0 +1,33 @@
+//go:build go1.21
+// +build go1.21
+
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	logger.Log(context.Background(), LogLevelDebug, "http request", LogField{"status", 200})
+	if buf.Len() != 0 {
+		t.Fatal("debug should be disabled", buf.String())
+	}
+	logger.Log(context.Background(), LogLevelError, "http request",
+		LogField{"status", 500}, LogField{"latency", time.Second}, LogField{"headers", map[string]string{"A": "1"}})
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); nil != err {
+		t.Fatal("Unmarshal", err, buf.String())
+	}
+	if entry["level"] != "ERROR" || entry["status"] != float64(500) || entry["latency"] != float64(time.Second) ||
+		entry["headers"].(map[string]interface{})["A"] != "1" {
+		t.Fatal("entry", buf.String())
+	}
+}
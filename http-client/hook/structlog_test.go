@@ -0,0 +1,213 @@
+package hook
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+// 记录日志，便于检查
+type recordLogger struct {
+	mutex   sync.Mutex
+	entries []logEntry
+}
+
+func (rl *recordLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	entry := logEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for _, field := range fields {
+		entry.fields[field.Key] = field.Value
+	}
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	rl.entries = append(rl.entries, entry)
+}
+
+func (rl *recordLogger) last() logEntry {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return rl.entries[len(rl.entries)-1]
+}
+
+func (rl *recordLogger) len() int {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return len(rl.entries)
+}
+
+func TestStructuredLogHook(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notfound":
+			w.WriteHeader(http.StatusNotFound)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			w.Header().Set("X-Request-Id", "1")
+			w.Write([]byte(body))
+		}
+	}))
+	defer server.Close()
+
+	logger := &recordLogger{}
+	client := core.NewClient("test", nil).AppendHook(
+		NewStructuredLogHook(StructuredLogSettings{Logger: logger, LogHeaders: true, LogBody: true, MaxBodySize: 10}),
+		NewHeaderHook(http.Header{"Authorization": {"Bearer secret"}}, true),
+	)
+	client.SetRetryPolicy(&core.BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+
+	resp, err := client.DoRequest(&TestRequest{RequestURL: server.URL + "/ok"})
+	if nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	// 记录body之后仍然可以读取完整的body
+	if resp.ToString() != body {
+		t.Fatal("body should be readable after logging")
+	}
+	entry := logger.last()
+	if entry.level != LogLevelInfo || entry.msg != "http request" || entry.fields["method"] != "GET" || entry.fields["url"] != server.URL+"/ok" ||
+		entry.fields["status"] != 200 || entry.fields["attempts"] != 1 || entry.fields["bytes"] != int64(100) {
+		t.Fatal("fields", entry.level, entry.fields)
+	}
+	if entry.fields["body"] != body[:10] || entry.fields["body_truncated"] != true {
+		t.Fatal("body should be truncated", entry.fields["body"])
+	}
+	reqHeaders := entry.fields["request_headers"].(map[string]string)
+	respHeaders := entry.fields["response_headers"].(map[string]string)
+	if reqHeaders["Authorization"] != "[REDACTED]" || respHeaders["Set-Cookie"] != "[REDACTED]" || respHeaders["X-Request-Id"] != "1" {
+		t.Fatal("headers should be redacted", reqHeaders, respHeaders)
+	}
+
+	client.DoRequest(&TestRequest{RequestURL: server.URL + "/notfound"})
+	if entry = logger.last(); entry.level != LogLevelWarn || entry.fields["status"] != 404 {
+		t.Fatal("4xx should be warn", entry.level, entry.fields)
+	}
+	client.DoRequest(&TestRequest{RequestURL: server.URL + "/error"})
+	if entry = logger.last(); entry.level != LogLevelError || entry.fields["status"] != 500 {
+		t.Fatal("5xx should be error", entry.level, entry.fields)
+	}
+	_, err = client.DoRequest(&TestRequest{RequestURL: "http://127.0.0.1:0/"})
+	if entry = logger.last(); entry.level != LogLevelError || !strings.Contains(err.Error(), entry.fields["error"].(string)) {
+		t.Fatal("failed request should be error", entry.level, entry.fields)
+	}
+}
+
+func TestStructuredLogHook_Sample(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	logger := &recordLogger{}
+	client := core.NewClient("test", nil).AppendHook(NewStructuredLogHook(StructuredLogSettings{Logger: logger, SampleRate: -1}))
+	client.DoRequest(&TestRequest{RequestURL: server.URL})
+	if logger.len() != 0 {
+		t.Fatal("success logs should be dropped")
+	}
+	client.DoRequest(&TestRequest{RequestURL: server.URL + "/error"})
+	if logger.len() != 1 {
+		t.Fatal("error logs should always be recorded")
+	}
+
+	// 慢请求总是记录
+	client = core.NewClient("test", nil).AppendHook(NewStructuredLogHook(StructuredLogSettings{Logger: logger, SampleRate: -1, SlowReqLong: time.Nanosecond}))
+	client.DoRequest(&TestRequest{RequestURL: server.URL})
+	if entry := logger.last(); logger.len() != 2 || entry.level != LogLevelWarn || entry.fields["slow"] != true {
+		t.Fatal("slow request should be recorded", entry.fields)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf)
+	logger.Log(context.Background(), LogLevelWarn, "http request",
+		LogField{"status", 404}, LogField{"latency", 1500 * time.Millisecond}, LogField{"headers", map[string]string{"A": "1"}})
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); nil != err {
+		t.Fatal("Unmarshal", err, buf.String())
+	}
+	if entry["level"] != "WARN" || entry["msg"] != "http request" || entry["status"] != float64(404) || entry["latency"] != "1.5s" ||
+		entry["headers"].(map[string]interface{})["A"] != "1" || !strings.HasSuffix(buf.String(), "}\n") {
+		t.Fatal("entry", buf.String())
+	}
+}
+
+func TestStructuredLogHook_BodyTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer server.Close()
+
+	logger := &recordLogger{}
+	c := core.NewClient("test", nil).SetMaxBodySize(10).AppendHook(NewStructuredLogHook(StructuredLogSettings{Logger: logger}))
+	if _, err := c.DoRequest(&TestRequest{RequestURL: server.URL}); nil == err {
+		t.Fatal("body should be too large")
+	}
+	if entry := logger.last(); entry.level != LogLevelError || entry.fields["body_too_large"] != true {
+		t.Fatal("body_too_large", entry)
+	}
+
+	// 没有Content-Length的响应
+	chunked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(strings.Repeat("a", 99)))
+	}))
+	defer chunked.Close()
+	// 记录body时读取超过最大长度
+	c = core.NewClient("test", nil).SetMaxBodySize(10).AppendHook(NewStructuredLogHook(StructuredLogSettings{Logger: logger, LogBody: true}))
+	if _, err := c.DoRequest(&TestRequest{RequestURL: chunked.URL}); nil != err {
+		t.Fatal("DoRequest", err)
+	}
+	if entry := logger.last(); entry.fields["body_too_large"] != true || nil != entry.fields["bytes"] {
+		t.Fatal("body_too_large for chunked response", entry)
+	}
+}
+
+func TestStructuredLogHook_Gzip(t *testing.T) {
+	body := strings.Repeat("gzip body ", 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte(body))
+		gw.Close()
+	}))
+	defer server.Close()
+
+	for _, max := range []int{10, 1000} {
+		logger := &recordLogger{}
+		client := core.NewClient("test", nil).AppendHook(
+			NewStructuredLogHook(StructuredLogSettings{Logger: logger, LogBody: true, MaxBodySize: max}))
+		resp, err := client.DoRequest(&TestRequest{RequestURL: server.URL})
+		if nil != err || resp.ToString() != body {
+			t.Fatal("DoRequest", err)
+		}
+		entry := logger.last()
+		if max < len(body) {
+			if entry.fields["body"] != body[:max] || entry.fields["body_truncated"] != true {
+				t.Fatal("decoded body should be truncated", entry.fields)
+			}
+		} else if entry.fields["body"] != body || nil != entry.fields["body_truncated"] {
+			t.Fatal("decoded body should be logged", entry.fields)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package hook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+const (
+	TraceHookKey = "TraceHook"
+
+	// W3C Trace Context 头部
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// 跟踪ID以及跨度ID
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// 跨度上下文，即 traceparent 以及 tracestate 头部携带的信息
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// 返回 traceparent 头部的值，如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// 解析 traceparent 头部
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+	// 版本00只有四个部分，之后的版本向后兼容
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, ErrInvalidTraceparent
+	}
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(parts[0])); nil != err {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); nil != err {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); nil != err {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); nil != err {
+		return sc, ErrInvalidTraceparent
+	}
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+	return sc, nil
+}
+
+// 从头部中提取跨度上下文，如服务端从收到的请求中提取，再通过ContextWithSpanContext传递给客户端
+func ExtractSpanContext(header http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if nil != err {
+		return SpanContext{}, false
+	}
+	sc.TraceState = strings.Join(header[http.CanonicalHeaderKey(TracestateHeader)], ",")
+	return sc, true
+}
+
+type spanContextKey struct{}
+
+// 返回附带跨度上下文的上下文，通过DoRequestContext()传入时，跟踪钩子以其为父跨度
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// 返回上下文附带的跨度上下文
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if nil == ctx {
+		return SpanContext{}, false
+	}
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// 跨度类型
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindClient   SpanKind = 3
+)
+
+// 跨度状态
+type SpanStatus int
+
+const (
+	SpanStatusUnset SpanStatus = 0
+	SpanStatusOK    SpanStatus = 1
+	SpanStatusError SpanStatus = 2
+)
+
+// 跨度
+//   每一次DoRequest()一个请求跨度，每一次尝试一个子跨度
+type Span struct {
+	Name          string
+	SpanContext   SpanContext
+	ParentSpanID  SpanID
+	Kind          SpanKind
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	Status        SpanStatus
+	StatusMessage string
+}
+
+// 跨度导出接口
+//   ExportSpans在请求结束时调用，请不要阻塞太久
+type SpanExporter interface {
+	ExportSpans(spans []*Span) error
+}
+
+// 跟踪钩子
+//   每一次DoRequest()创建一个请求跨度，每一次尝试（包括重试）创建一个子跨度，
+//   并以尝试跨度的上下文在请求中添加W3C traceparent、tracestate头部。
+//   请求上下文附带跨度上下文（见ContextWithSpanContext）时，请求跨度以其为父跨度，
+//   并沿用其采样标志以及tracestate；否则开始新的跟踪。
+//   请求结束时记录状态码、错误以及请求次数，采样的跨度通过SpanExporter导出。
+type TraceHook struct {
+	exporter SpanExporter
+}
+
+// 一次请求的跟踪数据
+type traceData struct {
+	mutex    sync.Mutex
+	span     *Span
+	attempts []*Span
+	// 发送的请求对应的尝试跨度
+	requests map[*http.Request]*Span
+	// 请求是否已经结束，避免重复导出
+	ended bool
+}
+
+func (th *TraceHook) BeforeRequest(req core.Request, client core.Client) error {
+	span := &Span{Kind: SpanKindInternal, Start: time.Now(), Attributes: make(map[string]interface{})}
+	if parent, ok := SpanContextFromContext(req.Context()); ok {
+		span.SpanContext = parent
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.SpanContext = SpanContext{TraceID: newTraceID(), Sampled: true}
+	}
+	span.SpanContext.SpanID = newSpanID()
+	req.SetHookData(TraceHookKey, &traceData{span: span, requests: make(map[*http.Request]*Span)})
+	return nil
+}
+
+func (th *TraceHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+	data := traceDataOf(req)
+	if nil == data {
+		return
+	}
+	now := time.Now()
+	method := requestMethod(req)
+
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	if data.ended {
+		return
+	}
+	data.ended = true
+
+	span := data.span
+	span.End = now
+	span.Name = req.ServerName()
+	if method != unknownMethod {
+		span.Name = method + " " + span.Name
+		span.Attributes["http.request.method"] = method
+	}
+	span.Attributes["server.name"] = req.ServerName()
+	span.Attributes["http.client.request_count"] = req.ReqCount()
+	resp := req.Response()
+	if nil != resp && nil != resp.Response {
+		span.Attributes["http.response.status_code"] = resp.StatusCode
+		span.Attributes["http.client.response_source"] = string(resp.Source())
+	}
+	setSpanStatus(span, cErr, resp)
+
+	// 尝试跨度按发送的请求对应尝试记录，
+	// 没有对应记录的（发送之前被其它钩子中断）以请求错误结束
+	ended := make(map[*Span]bool, len(data.attempts))
+	for _, a := range req.Attempts() {
+		attempt, ok := data.requests[a.Request]
+		if !ok {
+			continue
+		}
+		ended[attempt] = true
+		attempt.End = a.Start.Add(a.LongTime)
+		if a.StatusCode > 0 {
+			attempt.Attributes["http.response.status_code"] = a.StatusCode
+		}
+		if a.Hedge {
+			attempt.Attributes["http.client.hedge"] = true
+		}
+		if a.Won {
+			attempt.Attributes["http.client.hedge_won"] = true
+		}
+		setAttemptStatus(attempt, a.Err, a.StatusCode)
+	}
+	for _, attempt := range data.attempts {
+		if !ended[attempt] {
+			attempt.End = now
+			setAttemptStatus(attempt, cErr, 0)
+		}
+	}
+
+	if nil != th.exporter && span.SpanContext.Sampled {
+		spans := make([]*Span, 0, len(data.attempts)+1)
+		spans = append(spans, span)
+		spans = append(spans, data.attempts...)
+		th.exporter.ExportSpans(spans)
+	}
+}
+
+func (th *TraceHook) BeforeSend(httpReq *http.Request, req core.Request) error {
+	data := traceDataOf(req)
+	if nil == data {
+		return nil
+	}
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	if data.ended {
+		return nil
+	}
+
+	sc := data.span.SpanContext
+	sc.SpanID = newSpanID()
+	method := httpReq.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	attempt := &Span{
+		Name:         method,
+		SpanContext:  sc,
+		ParentSpanID: data.span.SpanContext.SpanID,
+		Kind:         SpanKindClient,
+		Start:        time.Now(),
+		Attributes: map[string]interface{}{
+			"http.request.method":       method,
+			"url.full":                  redactURL(httpReq),
+			"server.address":            httpReq.URL.Hostname(),
+			"http.request.resend_count": len(data.attempts),
+		},
+	}
+	data.attempts = append(data.attempts, attempt)
+	data.requests[httpReq] = attempt
+
+	httpReq.Header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		httpReq.Header.Set(TracestateHeader, sc.TraceState)
+	} else {
+		httpReq.Header.Del(TracestateHeader)
+	}
+	return nil
+}
+
+func (th *TraceHook) AfterReceive(httpResp *http.Response, req core.Request) error {
+	return nil
+}
+
+// 返回请求跨度的上下文，请求结束之后仍然可用
+func (th *TraceHook) SpanContext(req core.Request) (SpanContext, bool) {
+	data := traceDataOf(req)
+	if nil == data {
+		return SpanContext{}, false
+	}
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	return data.span.SpanContext, true
+}
+
+// 新建跟踪钩子
+// @params exporter 跨度导出，为nil时只传播头部，不导出
+func NewTraceHook(exporter SpanExporter) *TraceHook {
+	return &TraceHook{exporter: exporter}
+}
+
+func traceDataOf(req core.Request) *traceData {
+	data, ok := req.HookData(TraceHookKey)
+	if !ok {
+		return nil
+	}
+	td, _ := data.(*traceData)
+	return td
+}
+
+// 请求跨度状态：请求失败或者5xx为错误
+func setSpanStatus(span *Span, err error, resp *core.Response) {
+	if nil != err {
+		span.Status, span.StatusMessage = SpanStatusError, err.Error()
+		return
+	}
+	if nil != resp && nil != resp.Response && resp.StatusCode >= 500 {
+		span.Status, span.StatusMessage = SpanStatusError, http.StatusText(resp.StatusCode)
+	}
+}
+
+// 尝试跨度状态：客户端跨度4xx也为错误
+func setAttemptStatus(span *Span, err error, statusCode int) {
+	if nil != err {
+		span.Status, span.StatusMessage = SpanStatusError, err.Error()
+		return
+	}
+	if statusCode >= 400 {
+		span.Status, span.StatusMessage = SpanStatusError, http.StatusText(statusCode)
+	}
+}
+
+// 去掉URL中的用户信息
+func redactURL(httpReq *http.Request) string {
+	u := *httpReq.URL
+	u.User = nil
+	return u.String()
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return
+}
@@ -0,0 +1,354 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+var ErrExporterShutdown = errors.New("span exporter is shut down")
+
+const (
+	// 跟踪数据的 instrumentation scope 名称
+	traceScopeName = "github.com/BPing/go-toolkit/http-client"
+
+	defaultOTLPBatchSize     = 512
+	defaultOTLPMaxQueueSize  = 2048
+	defaultOTLPFlushInterval = 5 * time.Second
+	defaultOTLPTimeout       = 10 * time.Second
+)
+
+// 内存跨度导出，用于测试
+type InMemoryExporter struct {
+	mutex sync.Mutex
+	spans []*Span
+}
+
+func (e *InMemoryExporter) ExportSpans(spans []*Span) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// 已经导出的跨度
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// 清除已经导出的跨度
+func (e *InMemoryExporter) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = nil
+}
+
+// 新建内存跨度导出
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// OTLP/HTTP导出配置
+//
+// Endpoint 接收地址，如 http://localhost:4318/v1/traces
+//
+// Header 请求的其它头部，如认证信息
+//
+// ServiceName 服务名，即资源属性 service.name
+//
+// BatchSize 每一批最多导出的跨度数。如果为零，默认为512
+//
+// MaxQueueSize 等待导出的最大跨度数，超过时丢弃新的跨度。如果为零，默认为2048
+//
+// FlushInterval 定时导出的间隔。如果为零，默认为5秒
+//
+// OnError 导出失败时调用，可以为nil
+//
+// Client 发送导出请求的客户端。如果为nil，新建一个（超时时间为10秒）。
+//        请不要在此客户端上添加跟踪钩子，以免导出请求本身也被跟踪
+type OTLPSettings struct {
+	Endpoint      string
+	Header        http.Header
+	ServiceName   string
+	BatchSize     int
+	MaxQueueSize  int
+	FlushInterval time.Duration
+	OnError       func(err error)
+	Client        *core.Client
+}
+
+// OTLP/HTTP JSON跨度导出
+//   跨度先放入队列，达到BatchSize或者每隔FlushInterval在后台批量导出，
+//   导出请求以JSON编码POST到Endpoint。程序退出之前请调用Shutdown()导出剩余的跨度。
+type OTLPExporter struct {
+	settings OTLPSettings
+
+	mutex    sync.Mutex
+	queue    []*Span
+	dropped  uint64
+	shutdown bool
+
+	// 导出互斥，保证批次按顺序发送
+	exportMutex sync.Mutex
+	flush       chan struct{}
+	done        chan struct{}
+	stopped     chan struct{}
+}
+
+func (e *OTLPExporter) ExportSpans(spans []*Span) error {
+	e.mutex.Lock()
+	if e.shutdown {
+		e.mutex.Unlock()
+		return ErrExporterShutdown
+	}
+	for _, span := range spans {
+		if len(e.queue) >= e.settings.MaxQueueSize {
+			e.dropped++
+			continue
+		}
+		e.queue = append(e.queue, span)
+	}
+	full := len(e.queue) >= e.settings.BatchSize
+	e.mutex.Unlock()
+
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// 立即导出队列中所有的跨度
+func (e *OTLPExporter) Flush() error {
+	e.exportMutex.Lock()
+	defer e.exportMutex.Unlock()
+	for {
+		e.mutex.Lock()
+		n := len(e.queue)
+		if n > e.settings.BatchSize {
+			n = e.settings.BatchSize
+		}
+		batch := e.queue[:n:n]
+		e.queue = e.queue[n:]
+		e.mutex.Unlock()
+		if 0 == n {
+			return nil
+		}
+		if err := e.send(batch); nil != err {
+			return err
+		}
+	}
+}
+
+// 停止后台导出，并导出剩余的跨度
+func (e *OTLPExporter) Shutdown() error {
+	e.mutex.Lock()
+	if e.shutdown {
+		e.mutex.Unlock()
+		return nil
+	}
+	e.shutdown = true
+	e.mutex.Unlock()
+	close(e.done)
+	<-e.stopped
+	return e.Flush()
+}
+
+// 因队列已满而丢弃的跨度数
+func (e *OTLPExporter) Dropped() uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.dropped
+}
+
+func (e *OTLPExporter) loop() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.settings.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		case <-e.flush:
+		}
+		if err := e.Flush(); nil != err && nil != e.settings.OnError {
+			e.settings.OnError(err)
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*Span) error {
+	body, err := json.Marshal(otlpPayload(e.settings.ServiceName, spans))
+	if nil != err {
+		return err
+	}
+	resp, err := e.settings.Client.DoRequest(&otlpRequest{settings: &e.settings, body: body})
+	if nil != err {
+		return err
+	}
+	// 读取并关闭body，以便复用连接以及释放请求占用的资源
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("otlp export failed: status:" + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
+
+// 新建OTLP/HTTP JSON跨度导出，并开始后台导出
+func NewOTLPExporter(settings OTLPSettings) *OTLPExporter {
+	if settings.BatchSize <= 0 {
+		settings.BatchSize = defaultOTLPBatchSize
+	}
+	if settings.MaxQueueSize <= 0 {
+		settings.MaxQueueSize = defaultOTLPMaxQueueSize
+	}
+	if settings.FlushInterval <= 0 {
+		settings.FlushInterval = defaultOTLPFlushInterval
+	}
+	if nil == settings.Client {
+		settings.Client = core.NewClient("otlp", nil).SetTimeOut(defaultOTLPTimeout)
+	}
+	e := &OTLPExporter{
+		settings: settings,
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+// 导出请求
+type otlpRequest struct {
+	core.BaseRequest
+	settings *OTLPSettings
+	body     []byte
+}
+
+func (r *otlpRequest) HttpRequest() (*http.Request, error) {
+	httpReq, err := http.NewRequest(http.MethodPost, r.settings.Endpoint, bytes.NewReader(r.body))
+	if nil != err {
+		return nil, err
+	}
+	for k, v := range r.settings.Header {
+		httpReq.Header[k] = append([]string(nil), v...)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return httpReq, nil
+}
+
+func (r *otlpRequest) ServerName() string {
+	return "otlp:" + r.settings.Endpoint
+}
+
+func (r *otlpRequest) String() string {
+	return "otlp export:" + r.settings.Endpoint
+}
+
+// OTLP JSON编码，见 opentelemetry-proto 的 ExportTraceServiceRequest。
+// ID以十六进制字符串编码，64位整数以字符串编码
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    SpanStatus `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+func otlpPayload(serviceName string, spans []*Span) map[string]interface{} {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.SpanContext.TraceID.String(),
+			SpanID:            span.SpanContext.SpanID.String(),
+			TraceState:        span.SpanContext.TraceState,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: span.Status, Message: span.StatusMessage},
+		}
+		if span.ParentSpanID.IsValid() {
+			s.ParentSpanID = span.ParentSpanID.String()
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+	var resource []otlpKeyValue
+	if serviceName != "" {
+		resource = otlpAttributes(map[string]interface{}{"service.name": serviceName})
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": resource},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": traceScopeName, "version": core.Version},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// 属性按键排序，便于比较
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		var value map[string]interface{}
+		switch v := attrs[k].(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: value})
+	}
+	return kvs
+}
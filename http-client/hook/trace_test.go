@@ -0,0 +1,336 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BPing/go-toolkit/http-client/core"
+)
+
+func TestTraceHook(t *testing.T) {
+	var mutex sync.Mutex
+	var received []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		received = append(received, r.Header)
+		n := len(received)
+		mutex.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	exporter := NewInMemoryExporter()
+	trace := NewTraceHook(exporter)
+	client := core.NewClient("test", nil).AppendHook(trace)
+	client.SetRetryPolicy(&core.BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+
+	parent, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if nil != err {
+		t.Fatal("ParseTraceparent", err)
+	}
+	parent.TraceState = "vendor=abc"
+	req := &TestRequest{RequestURL: server.URL}
+	resp, err := client.DoRequestContext(ContextWithSpanContext(context.Background(), parent), req)
+	if nil != err || resp.ToString() != "ok" {
+		t.Fatal("DoRequest", err)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatal("should export request span and 2 attempt spans", len(spans))
+	}
+	span := spans[0]
+	if span.SpanContext.TraceID != parent.TraceID || span.ParentSpanID != parent.SpanID || span.Kind != SpanKindInternal {
+		t.Fatal("request span should be child of parent", span)
+	}
+	if span.Attributes["http.client.request_count"] != 2 || span.Attributes["http.response.status_code"] != 200 || span.Status != SpanStatusUnset {
+		t.Fatal("request span", span.Attributes, span.Status)
+	}
+	if sc, ok := trace.SpanContext(req); !ok || sc != span.SpanContext {
+		t.Fatal("SpanContext", sc)
+	}
+	for i, attempt := range spans[1:] {
+		if attempt.ParentSpanID != span.SpanContext.SpanID || attempt.SpanContext.TraceID != parent.TraceID || attempt.Kind != SpanKindClient {
+			t.Fatal("attempt span should be child of request span", attempt)
+		}
+		if attempt.Attributes["http.request.resend_count"] != i {
+			t.Fatal("resend_count", attempt.Attributes)
+		}
+		// 每一次尝试以其跨度传播
+		if received[i].Get(TraceparentHeader) != attempt.SpanContext.Traceparent() || received[i].Get(TracestateHeader) != "vendor=abc" {
+			t.Fatal("propagation", received[i])
+		}
+		if attempt.End.Before(attempt.Start) {
+			t.Fatal("attempt span time", attempt.Start, attempt.End)
+		}
+	}
+	if spans[1].Attributes["http.response.status_code"] != 503 || spans[1].Status != SpanStatusError {
+		t.Fatal("first attempt", spans[1].Attributes, spans[1].Status)
+	}
+	if spans[2].Attributes["http.response.status_code"] != 200 || spans[2].Status != SpanStatusUnset {
+		t.Fatal("second attempt", spans[2].Attributes, spans[2].Status)
+	}
+
+	// 没有父跨度时开始新的跟踪，请求失败时记录错误
+	exporter.Reset()
+	server.Close()
+	_, err = client.DoRequest(&TestRequest{RequestURL: server.URL})
+	spans = exporter.Spans()
+	if nil == err || len(spans) != 3 {
+		t.Fatal("request should fail", err, len(spans))
+	}
+	if spans[0].ParentSpanID.IsValid() || spans[0].SpanContext.TraceID == parent.TraceID || !spans[0].SpanContext.Sampled {
+		t.Fatal("should start a new trace", spans[0].SpanContext)
+	}
+	if spans[0].Status != SpanStatusError || !strings.Contains(err.Error(), spans[0].StatusMessage) || spans[2].Status != SpanStatusError {
+		t.Fatal("error status", spans[0].Status, spans[2].Status)
+	}
+
+	// 未采样时只传播，不导出
+	exporter.Reset()
+	parent.Sampled = false
+	client.DoRequestContext(ContextWithSpanContext(context.Background(), parent), &TestRequest{RequestURL: server.URL})
+	if len(exporter.Spans()) != 0 {
+		t.Fatal("unsampled spans should not be exported")
+	}
+}
+
+func TestTraceHook_Rejected(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	client := core.NewClient("test", nil).AppendHook(NewTraceHook(exporter), &rejectHook{})
+	_, err := client.DoRequest(&TestRequest{RequestURL: "http://127.0.0.1/"})
+	spans := exporter.Spans()
+	if err != errRejected || len(spans) != 1 || spans[0].Attributes["http.client.request_count"] != 0 || spans[0].Status != SpanStatusError {
+		t.Fatal("rejected request", err, spans)
+	}
+}
+
+// 第n次BeforeSend返回错误的传输钩子
+type failSendHook struct {
+	n     int32
+	count int32
+}
+
+func (h *failSendHook) BeforeRequest(req core.Request, client core.Client) error {
+	return nil
+}
+
+func (h *failSendHook) AfterRequest(cErr error, req core.Request, client core.Client) {
+}
+
+func (h *failSendHook) BeforeSend(httpReq *http.Request, req core.Request) error {
+	if atomic.AddInt32(&h.count, 1) == h.n {
+		return errRejected
+	}
+	return nil
+}
+
+func (h *failSendHook) AfterReceive(httpResp *http.Response, req core.Request) error {
+	return nil
+}
+
+// 第一次尝试慢并且失败，对冲请求在之后的钩子的BeforeSend中失败，没有发送
+func newHedgeNotSentClient(server *httptest.Server, hook core.Hook) (*core.Client, *TestRequest) {
+	client := core.NewClient("test", nil).AppendHook(hook, &failSendHook{n: 2}).
+		SetHedgePolicy(&core.HedgePolicy{Delay: 20 * time.Millisecond, MaxPercent: 1}).
+		SetRetryPolicy(&core.BackoffRetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+	req := &TestRequest{RequestURL: server.URL}
+	req.SetHedge(true)
+	return client, req
+}
+
+func TestTraceHook_HedgeNotSent(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	exporter := NewInMemoryExporter()
+	client, req := newHedgeNotSentClient(server, NewTraceHook(exporter))
+	resp, err := client.DoRequest(req)
+	if nil != err || resp.ToString() != "ok" {
+		t.Fatal("DoRequest", err)
+	}
+	spans := exporter.Spans()
+	if len(spans) != 4 {
+		t.Fatal("should export request span and 3 attempt spans", len(spans))
+	}
+	// 没有发送的对冲请求不影响之后的尝试对应的记录
+	if spans[1].Attributes["http.response.status_code"] != 503 || spans[1].Status != SpanStatusError {
+		t.Fatal("first attempt", spans[1].Attributes, spans[1].Status)
+	}
+	if nil != spans[2].Attributes["http.response.status_code"] {
+		t.Fatal("hedge not sent", spans[2].Attributes)
+	}
+	if spans[3].Attributes["http.response.status_code"] != 200 || spans[3].Status != SpanStatusUnset {
+		t.Fatal("second attempt", spans[3].Attributes, spans[3].Status)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	cases := map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":     true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-ext": true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-ext": false,
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":     false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01":     false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01":     false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bx-01":     false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7":        false,
+		"": false,
+	}
+	for traceparent, valid := range cases {
+		sc, err := ParseTraceparent(traceparent)
+		if valid != (nil == err) {
+			t.Fatal("ParseTraceparent", traceparent, err)
+		}
+		if valid && (!sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7") {
+			t.Fatal("ParseTraceparent", traceparent, sc)
+		}
+	}
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if sc.Sampled || sc.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00" {
+		t.Fatal("Traceparent", sc)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	payloads := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Api-Key") != "key" {
+			t.Error("export request", r.Method, r.Header)
+		}
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); nil != err {
+			t.Error("Decode", err)
+		}
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPExporter(OTLPSettings{
+		Endpoint:      server.URL + "/v1/traces",
+		Header:        http.Header{"X-Api-Key": {"key"}},
+		ServiceName:   "demo",
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	client := core.NewClient("test", nil).AppendHook(NewTraceHook(exporter))
+	server2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server2.Close()
+	client.DoRequest(&TestRequest{RequestURL: server2.URL})
+
+	// 达到BatchSize时在后台导出
+	var payload map[string]interface{}
+	select {
+	case payload = <-payloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("spans should be exported when batch is full")
+	}
+	resourceSpans := payload["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	resource := resourceSpans["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if resource["key"] != "service.name" || resource["value"].(map[string]interface{})["stringValue"] != "demo" {
+		t.Fatal("resource", resource)
+	}
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 2 {
+		t.Fatal("spans", len(spans))
+	}
+	span := spans[0].(map[string]interface{})
+	if len(span["traceId"].(string)) != 32 || len(span["spanId"].(string)) != 16 || span["kind"] != float64(SpanKindInternal) {
+		t.Fatal("span", span)
+	}
+	attempt := spans[1].(map[string]interface{})
+	if attempt["parentSpanId"] != span["spanId"] || attempt["kind"] != float64(SpanKindClient) {
+		t.Fatal("attempt span", attempt)
+	}
+	found := false
+	for _, attr := range attempt["attributes"].([]interface{}) {
+		kv := attr.(map[string]interface{})
+		if kv["key"] == "http.response.status_code" {
+			found = kv["value"].(map[string]interface{})["intValue"] == "200"
+		}
+	}
+	if !found {
+		t.Fatal("attempt attributes", attempt["attributes"])
+	}
+
+	// Shutdown导出剩余的跨度
+	exporter.ExportSpans([]*Span{{Name: "left", SpanContext: SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}}})
+	if err := exporter.Shutdown(); nil != err {
+		t.Fatal("Shutdown", err)
+	}
+	select {
+	case <-payloads:
+	default:
+		t.Fatal("remaining spans should be exported on shutdown")
+	}
+	if err := exporter.ExportSpans(nil); err != ErrExporterShutdown {
+		t.Fatal("ExportSpans after shutdown", err)
+	}
+}
+
+// 记录body关闭次数的Transport
+type closeCountTransport struct {
+	closed int32
+}
+
+func (t *closeCountTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	if nil == err {
+		resp.Body = &closeCountBody{ReadCloser: resp.Body, closed: &t.closed}
+	}
+	return resp, err
+}
+
+type closeCountBody struct {
+	io.ReadCloser
+	closed *int32
+}
+
+func (b *closeCountBody) Close() error {
+	atomic.AddInt32(b.closed, 1)
+	return b.ReadCloser.Close()
+}
+
+func TestOTLPExporter_CloseBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	transport := &closeCountTransport{}
+	exporter := NewOTLPExporter(OTLPSettings{
+		Endpoint:      server.URL,
+		FlushInterval: time.Hour,
+		Client:        core.NewClientBuilder("otlp").Transport(transport).Build(),
+	})
+	defer exporter.Shutdown()
+	for i := 0; i < 3; i++ {
+		exporter.ExportSpans([]*Span{{Name: "span", SpanContext: SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}}})
+		if err := exporter.Flush(); nil != err {
+			t.Fatal("Flush", err)
+		}
+	}
+	if closed := atomic.LoadInt32(&transport.closed); closed != 3 {
+		t.Fatal("response body should be closed", closed)
+	}
+}